@@ -0,0 +1,371 @@
+package main
+
+import (
+	"birthsch/conf"
+	"birthsch/datafile"
+	"birthsch/ical"
+	"birthsch/idl"
+	"birthsch/importer"
+	"birthsch/notify"
+	"birthsch/sch"
+	"birthsch/watch"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [options] [command [command options]]\n", os.Args[0])
+	fmt.Fprintln(out, "Without a command the scheduler service is started.")
+	fmt.Fprintln(out, "Commands:")
+	fmt.Fprintln(out, "  export-ics    export all events as iCalendar file")
+	fmt.Fprintln(out, "  import        import events from .ics and .vcf files into the data file")
+	fmt.Fprintln(out, "  list          list all events sorted by next occurrence")
+	fmt.Fprintln(out, "  next          list the events of the next days (-days N)")
+	fmt.Fprintln(out, "  validate      check the configuration and the data file")
+	fmt.Fprintln(out, "  preview       show what would be sent on a day (-date YYYY-MM-DD), nothing is sent")
+	fmt.Fprintln(out, "  ack           acknowledge an alarm with its token, the service must be running")
+	fmt.Fprintln(out, "Options:")
+	flag.PrintDefaults()
+}
+
+func runCommand(configfile string, args []string) error {
+	switch args[0] {
+	case "export-ics":
+		return exportIcsCmd(configfile, args[1:])
+	case "import":
+		return importCmd(configfile, args[1:])
+	case "list":
+		return listCmd(configfile, args[1:])
+	case "next":
+		return nextCmd(configfile, args[1:])
+	case "validate":
+		return validateCmd(configfile, args[1:])
+	case "preview":
+		return previewCmd(configfile, args[1:])
+	case "ack":
+		return ackCmd(configfile, args[1:])
+	}
+	return fmt.Errorf("command %q not recognized, use -h for help", args[0])
+}
+
+func exportIcsCmd(configfile string, args []string) error {
+	fs := flag.NewFlagSet("export-ics", flag.ExitOnError)
+	outfile := fs.String("out", "events.ics", "Output iCalendar file, - for stdout")
+	fs.Parse(args)
+
+	if _, err := conf.ReadConfig(configfile); err != nil {
+		return err
+	}
+	schList, err := datafile.Read(conf.Current().DataFileName)
+	if err != nil {
+		return err
+	}
+	opt := ical.ExportOptions{LeadDays: conf.Current().LeadDays, AlarmClock: conf.Current().AlarmClock}
+	if *outfile == "-" {
+		return ical.Export(os.Stdout, schList, &opt)
+	}
+	f, err := os.Create(*outfile)
+	if err != nil {
+		return err
+	}
+	if err := ical.Export(f, schList, &opt); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Println("Calendar exported to ", *outfile)
+	return nil
+}
+
+func importCmd(configfile string, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Report what would be added, changed or skipped without writing the data file")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("import needs at least one .ics or .vcf file")
+	}
+
+	if _, err := conf.ReadConfig(configfile); err != nil {
+		return err
+	}
+	schList, err := datafile.Read(conf.Current().DataFileName)
+	if err != nil {
+		return err
+	}
+	counts := map[string]int{}
+	for _, fname := range fs.Args() {
+		items, report, err := importer.ReadFile(fname)
+		if err != nil {
+			return err
+		}
+		merged, err := importer.Merge(schList, items, filepath.Base(fname))
+		if err != nil {
+			return err
+		}
+		for _, entry := range append(report, merged...) {
+			fmt.Println(entry)
+			counts[entry.Action] += 1
+		}
+	}
+	fmt.Printf("Added %d, changed %d, skipped %d\n",
+		counts[importer.ActionAdded], counts[importer.ActionChanged], counts[importer.ActionSkipped])
+	if *dryRun {
+		fmt.Println("Dry run, data file is not changed")
+		return nil
+	}
+	if counts[importer.ActionAdded]+counts[importer.ActionChanged] == 0 {
+		return nil
+	}
+	return datafile.Write(conf.Current().DataFileName, schList)
+}
+
+func ackCmd(configfile string, args []string) error {
+	fs := flag.NewFlagSet("ack", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("ack needs the alarm token")
+	}
+
+	if _, err := conf.ReadConfig(configfile); err != nil {
+		return err
+	}
+	if !conf.Current().Http.Enabled {
+		return fmt.Errorf("the [Http] server of the service is not enabled")
+	}
+	addr := conf.Current().Http.Address
+	if host, port, err := net.SplitHostPort(addr); err == nil && (host == "" || host == "0.0.0.0") {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+	ackURL := "http://" + addr + "/ack/" + url.PathEscape(fs.Arg(0))
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.PostForm(ackURL, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("alarm %s not acknowledged: %s", fs.Arg(0), resp.Status)
+	}
+	fmt.Println("Alarm acknowledged")
+	return nil
+}
+
+func listCmd(configfile string, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Parse(args)
+	// every event occurs within one year and a day (Feb 29 excluded)
+	return printUpcoming(configfile, 367)
+}
+
+func nextCmd(configfile string, args []string) error {
+	fs := flag.NewFlagSet("next", flag.ExitOnError)
+	days := fs.Int("days", 30, "Number of days to look ahead")
+	fs.Parse(args)
+	if *days <= 0 {
+		return fmt.Errorf("days must be positive")
+	}
+	return printUpcoming(configfile, *days)
+}
+
+func printUpcoming(configfile string, days int) error {
+	if _, err := conf.ReadConfig(configfile); err != nil {
+		return err
+	}
+	schList, err := datafile.Read(conf.Current().DataFileName)
+	if err != nil {
+		return err
+	}
+	if err := schList.Validate(); err != nil {
+		return err
+	}
+	items, err := schList.Upcoming(conf.Current().Now(), days)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tDAYS\tTYPE\tNAME\tYEARS\tNOTE")
+	for _, item := range items {
+		years := ""
+		if item.Years > 0 {
+			years = strconv.Itoa(item.Years)
+			if item.Milestone {
+				years += "*"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\n", item.Time.Format("Mon 2006-01-02"), item.DaysLeft, item.EventType, item.Name, years, item.Note)
+	}
+	return tw.Flush()
+}
+
+func validateCmd(configfile string, args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Parse(args)
+
+	if _, err := conf.ReadConfig(configfile); err != nil {
+		return fmt.Errorf("config %s: %v", configfile, err)
+	}
+	errs := validateConfig()
+	entries := 0
+	schList, err := datafile.Read(conf.Current().DataFileName)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("data file %s: %v", conf.Current().DataFileName, err))
+	} else {
+		entries = len(schList.List)
+		errs = append(errs, schList.Errors()...)
+		for i, item := range schList.List {
+			if item.Escalation != "" && conf.Current().EscalationFor(item.Escalation, "") == nil {
+				errs = append(errs, fmt.Errorf("entry %d (%s): escalation policy %q not found", i, item.Name, item.Escalation))
+			}
+			if _, ok := conf.Current().Relay.Groups[item.Group]; item.Group != "" && !ok {
+				errs = append(errs, fmt.Errorf("entry %d (%s): mail group %q not found", i, item.Name, item.Group))
+			}
+			for _, name := range item.Chats {
+				if conf.Current().Telegram.Chat(name) == nil {
+					errs = append(errs, fmt.Errorf("entry %d (%s): telegram chat %q not found", i, item.Name, name))
+				}
+			}
+		}
+	}
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d errors found", len(errs))
+	}
+	fmt.Printf("Configuration and data file are valid, %d entries\n", entries)
+	return nil
+}
+
+// validateConfig checks the parts of the configuration that are used only by the service
+func validateConfig() []error {
+	errs := make([]error, 0)
+	for _, wcfg := range conf.Current().Watch {
+		if _, err := watch.New(wcfg, nil); err != nil {
+			errs = append(errs, fmt.Errorf("config: %v", err))
+		}
+	}
+	reg := notify.NewRegistry(conf.Current(), true, false)
+	checkChannels := func(section string, names []string) {
+		for _, name := range names {
+			if reg.Get(name) == nil {
+				errs = append(errs, fmt.Errorf("config %s: channel %q is not enabled", section, name))
+			}
+		}
+	}
+	names := map[string]bool{}
+	for i, esc := range conf.Current().Escalation {
+		if esc.Name == "" {
+			errs = append(errs, fmt.Errorf("config Escalation %d: Name is empty", i))
+		} else if names[esc.Name] {
+			errs = append(errs, fmt.Errorf("config Escalation %d: Name %q is not unique", i, esc.Name))
+		}
+		names[esc.Name] = true
+		for _, tt := range esc.Types {
+			if tt != idl.Birthday.String() && tt != idl.Anniversary.String() {
+				errs = append(errs, fmt.Errorf("config Escalation %s: type %q not recognized", esc.Name, tt))
+			}
+		}
+		checkChannels("Escalation "+esc.Name, esc.EscalateChannels)
+	}
+	checkChannels("Digest", conf.Current().Digest.Channels)
+	checkRecipients := func(section string, rcpt conf.Recipients) {
+		if rcpt.Empty() {
+			errs = append(errs, fmt.Errorf("config %s: no recipients", section))
+		}
+		for _, addr := range rcpt.All() {
+			if _, err := mail.ParseAddress(addr); err != nil {
+				errs = append(errs, fmt.Errorf("config %s: recipient %q: %v", section, addr, err))
+			}
+		}
+	}
+	if conf.Current().Relay.SendMail {
+		checkRecipients("Relay", conf.Current().Relay.DefaultRecipients())
+		if caFile := conf.Current().Relay.CAFile; caFile != "" {
+			if _, err := os.Stat(caFile); err != nil {
+				errs = append(errs, fmt.Errorf("config Relay: CAFile %v", err))
+			}
+		}
+	}
+	errs = append(errs, validateChats(conf.Current().Telegram)...)
+	groups := make([]string, 0, len(conf.Current().Relay.Groups))
+	for name := range conf.Current().Relay.Groups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	for _, name := range groups {
+		checkRecipients("Relay.Groups."+name, *conf.Current().Relay.Groups[name])
+	}
+	return errs
+}
+
+// validateChats checks the telegram chats and the names that refer to them
+func validateChats(tg *conf.Telegram) []error {
+	errs := make([]error, 0)
+	names := map[string]bool{}
+	for i, chat := range tg.Chats {
+		if chat.Name == "" {
+			errs = append(errs, fmt.Errorf("config Telegram.Chats %d: Name is empty", i))
+		} else if names[chat.Name] {
+			errs = append(errs, fmt.Errorf("config Telegram.Chats %d: Name %q is not unique", i, chat.Name))
+		}
+		names[chat.Name] = true
+		if chat.ChatID == 0 && chat.Username == "" {
+			errs = append(errs, fmt.Errorf("config Telegram.Chats %s: ChatID or Username is needed", chat.Name))
+		}
+	}
+	checkNames := func(section string, list []string) {
+		for _, name := range list {
+			if !names[name] {
+				errs = append(errs, fmt.Errorf("config %s: chat %q not found", section, name))
+			}
+		}
+	}
+	checkNames("Telegram.DefaultChats", tg.DefaultChats)
+	tags := make([]string, 0, len(tg.TagChats))
+	for tag := range tg.TagChats {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	for _, tag := range tags {
+		checkNames("Telegram.TagChats."+tag, tg.TagChats[tag])
+	}
+	if tg.SendTelegram && len(tg.DefaultTargets()) == 0 {
+		errs = append(errs, fmt.Errorf("config Telegram: no default chat, set ChatID or DefaultChats"))
+	}
+	if tg.EnableBot && len(tg.BotChatIDs()) == 0 {
+		errs = append(errs, fmt.Errorf("config Telegram: the bot has no authorized chat, set AuthorizedChatIDs, ChatID or the ChatID of a chat"))
+	}
+	return errs
+}
+
+func previewCmd(configfile string, args []string) error {
+	fs := flag.NewFlagSet("preview", flag.ExitOnError)
+	date := fs.String("date", "", "Day to preview as YYYY-MM-DD, default today")
+	fs.Parse(args)
+
+	if _, err := conf.ReadConfig(configfile); err != nil {
+		return err
+	}
+	day := conf.Current().Now()
+	if *date != "" {
+		var err error
+		if day, err = time.ParseInLocation("2006-01-02", *date, conf.Current().Location()); err != nil {
+			return fmt.Errorf("invalid date %q, use YYYY-MM-DD", *date)
+		}
+	}
+	return sch.Preview(os.Stdout, day)
+}
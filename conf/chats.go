@@ -0,0 +1,85 @@
+package conf
+
+import (
+	"log"
+)
+
+// defaultChatName is the name of the chat of ChatID
+const defaultChatName = "default"
+
+// Chat returns the chat with the name, nil if it is not in the config
+func (t *Telegram) Chat(name string) *TelegramChat {
+	for _, chat := range t.Chats {
+		if chat.Name == name {
+			return chat
+		}
+	}
+	return nil
+}
+
+// DefaultTargets are the chats of the alarms that are not routed by event or tag
+func (t *Telegram) DefaultTargets() []TelegramChat {
+	if len(t.DefaultChats) > 0 {
+		return t.resolve(t.DefaultChats)
+	}
+	if t.ChatID != 0 {
+		return []TelegramChat{{Name: defaultChatName, ChatID: t.ChatID}}
+	}
+	return nil
+}
+
+// ChatsFor returns the chats of an event: its own chats, otherwise the chats
+// of its tags, otherwise the default ones
+func (t *Telegram) ChatsFor(chats []string, tags []string) []TelegramChat {
+	names := chats
+	if len(names) == 0 {
+		for _, tag := range tags {
+			names = append(names, t.TagChats[tag]...)
+		}
+	}
+	if res := t.resolve(names); len(res) > 0 {
+		return res
+	}
+	return t.DefaultTargets()
+}
+
+// ChatIDs returns ChatID and the IDs of the chats, a chat with only a Username
+// has no ID
+func (t *Telegram) ChatIDs() []int64 {
+	res := make([]int64, 0, len(t.Chats)+1)
+	if t.ChatID != 0 {
+		res = append(res, t.ChatID)
+	}
+	for _, chat := range t.Chats {
+		if chat.ChatID != 0 {
+			res = append(res, chat.ChatID)
+		}
+	}
+	return res
+}
+
+// BotChatIDs are the chats allowed to send commands to the bot: AuthorizedChatIDs,
+// when it is empty ChatID and the configured chats
+func (t *Telegram) BotChatIDs() []int64 {
+	if len(t.AuthorizedChatIDs) > 0 {
+		return t.AuthorizedChatIDs
+	}
+	return t.ChatIDs()
+}
+
+func (t *Telegram) resolve(names []string) []TelegramChat {
+	res := make([]TelegramChat, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if chat := t.Chat(name); chat != nil {
+			res = append(res, *chat)
+		} else {
+			log.Printf("telegram chat %q not found", name)
+		}
+	}
+	return res
+}
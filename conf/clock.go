@@ -0,0 +1,172 @@
+package conf
+
+import (
+	"fmt"
+	"time"
+	_ "time/tzdata" // the Timezone works also on hosts without zoneinfo
+)
+
+const defaultAlarmTime = "09:00"
+
+// clock is the parsed time related configuration
+type clock struct {
+	location   *time.Location
+	alarm      int
+	alarmTypes map[string]int
+	quiet      bool
+	quietStart int
+	quietEnd   int
+	digest     int
+	weekday    time.Weekday
+}
+
+func (cfg *Config) parseClock() error {
+	res := clock{location: time.Local, alarmTypes: map[string]int{}}
+	if cfg.Timezone != "" {
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return fmt.Errorf("Timezone: %v", err)
+		}
+		res.location = loc
+	}
+	if cfg.AlarmTime == "" {
+		cfg.AlarmTime = defaultAlarmTime
+	}
+	var err error
+	if res.alarm, err = parseTimeOfDay(cfg.AlarmTime); err != nil {
+		return fmt.Errorf("AlarmTime: %v", err)
+	}
+	for tt, s := range cfg.AlarmTimes {
+		if tt != "Compl" && tt != "Anniv" {
+			return fmt.Errorf("AlarmTimes: type %s not recognized", tt)
+		}
+		if res.alarmTypes[tt], err = parseTimeOfDay(s); err != nil {
+			return fmt.Errorf("AlarmTimes %s: %v", tt, err)
+		}
+	}
+	if cfg.QuietHours != nil && (cfg.QuietHours.Start != "" || cfg.QuietHours.End != "") {
+		if res.quietStart, err = parseTimeOfDay(cfg.QuietHours.Start); err != nil {
+			return fmt.Errorf("QuietHours Start: %v", err)
+		}
+		if res.quietEnd, err = parseTimeOfDay(cfg.QuietHours.End); err != nil {
+			return fmt.Errorf("QuietHours End: %v", err)
+		}
+		res.quiet = res.quietStart != res.quietEnd
+	}
+	if err := res.parseDigest(cfg); err != nil {
+		return err
+	}
+	cfg.clk = res
+	return nil
+}
+
+func (res *clock) parseDigest(cfg *Config) error {
+	if cfg.Digest == nil {
+		cfg.Digest = &Digest{}
+	}
+	dg := cfg.Digest
+	res.digest = res.alarm
+	if dg.Time != "" {
+		var err error
+		if res.digest, err = parseTimeOfDay(dg.Time); err != nil {
+			return fmt.Errorf("Digest Time: %v", err)
+		}
+	}
+	if dg.WeekDay == "" {
+		dg.WeekDay = time.Monday.String()
+	}
+	found := false
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		if wd.String() == dg.WeekDay {
+			res.weekday = wd
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("Digest WeekDay %q not recognized, use Monday, Tuesday, ...", dg.WeekDay)
+	}
+	if dg.MonthDay == 0 {
+		dg.MonthDay = 1
+	}
+	if dg.MonthDay < 1 || dg.MonthDay > 28 {
+		return fmt.Errorf("Digest MonthDay %d must be from 1 to 28", dg.MonthDay)
+	}
+	return nil
+}
+
+// parseTimeOfDay returns the minutes after midnight of a time like 09:30
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func minutesOfDay(t time.Time) int {
+	return t.Hour()*60 + t.Minute()
+}
+
+// Location is the configured Timezone, the local one of the host when not set
+func (cfg *Config) Location() *time.Location {
+	if cfg.clk.location == nil {
+		return time.Local
+	}
+	return cfg.clk.location
+}
+
+// Now is the current time in the configured Timezone
+func (cfg *Config) Now() time.Time {
+	return time.Now().In(cfg.Location())
+}
+
+// AlarmClock returns hour and minute of the alarm for the event type
+func (cfg *Config) AlarmClock(eventType string) (int, int) {
+	mm, ok := cfg.clk.alarmTypes[eventType]
+	if !ok {
+		mm = cfg.clk.alarm
+	}
+	return mm / 60, mm % 60
+}
+
+// DigestAt returns the time of the weekly or monthly digest on the day of t,
+// false when t is not a digest day
+func (cfg *Config) DigestAt(t time.Time, monthly bool) (time.Time, bool) {
+	dg := cfg.Digest
+	if dg == nil {
+		return time.Time{}, false
+	}
+	if monthly && (!dg.Monthly || t.Day() != dg.MonthDay) {
+		return time.Time{}, false
+	}
+	if !monthly && (!dg.Weekly || t.Weekday() != cfg.clk.weekday) {
+		return time.Time{}, false
+	}
+	mm := cfg.clk.digest
+	return time.Date(t.Year(), t.Month(), t.Day(), mm/60, mm%60, 0, 0, t.Location()), true
+}
+
+// QuietUntil returns the end of the quiet hours when t is inside them
+func (cfg *Config) QuietUntil(t time.Time) (time.Time, bool) {
+	clk := &cfg.clk
+	if !clk.quiet {
+		return time.Time{}, false
+	}
+	now := minutesOfDay(t)
+	endDay := t
+	if clk.quietStart < clk.quietEnd {
+		if now < clk.quietStart || now >= clk.quietEnd {
+			return time.Time{}, false
+		}
+	} else {
+		// the window crosses midnight
+		if now < clk.quietStart && now >= clk.quietEnd {
+			return time.Time{}, false
+		}
+		if now >= clk.quietStart {
+			endDay = t.AddDate(0, 0, 1)
+		}
+	}
+	end := time.Date(endDay.Year(), endDay.Month(), endDay.Day(), clk.quietEnd/60, clk.quietEnd%60, 0, 0, t.Location())
+	return end, true
+}
@@ -5,25 +5,113 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync/atomic"
 
 	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
-	DataFileName  string
-	Relay         *Relay
-	Telegram      *Telegram
-	SimulateAlarm bool
-	Debug         bool
-	UrlToCheck    string
+	DataFileName    string
+	JournalFileName string
+	WatchStateFile  string
+	LeadDays        []int
+	Timezone        string
+	AlarmTime       string
+	AlarmTimes      map[string]string
+	QuietHours      *QuietHours
+	Digest          *Digest
+	Relay           *Relay
+	Telegram        *Telegram
+	Retry           *Retry
+	Http            *Http
+	SimulateAlarm   bool
+	Debug           bool
+	Watch           []*Watch
+	UrlToCheck      string
+	Escalation      []*Escalation
+	clk             clock
 }
 
+// Digest is the weekly and monthly summary of the events. Channels empty
+// is for all the enabled ones, Time empty for the AlarmTime.
+type Digest struct {
+	Channels []string
+	Weekly   bool
+	WeekDay  string
+	Monthly  bool
+	MonthDay int
+	Time     string
+}
+
+// QuietHours is the daily window, like 22:00-07:00, where nothing is
+// delivered. The notifications are deferred to the End of the window.
+type QuietHours struct {
+	Start string
+	End   string
+}
+
+// Escalation is applied to the alarms that are not acknowledged in time.
+// An event uses the policy named in its Escalation field, otherwise the
+// first policy that lists its type (Compl or Anniv).
+type Escalation struct {
+	Name               string
+	Types              []string
+	ResendAfterHours   int
+	EscalateAfterHours int
+	EscalateChannels   []string
+	EscalateEmails     []string
+}
+
+type Watch struct {
+	Name          string
+	URL           string
+	Selector      string
+	Mode          string
+	Pattern       string
+	IntervalMin   int
+	AfterTrigger  string
+	CooldownHours int
+}
+
+// Telegram sends the alarms to DefaultChats, the names of some of the Chats.
+// ChatID is the single chat of the first versions, used when DefaultChats is
+// empty. An event goes to its own chats or to the chats of its tags in TagChats.
 type Telegram struct {
-	SendTelegram bool
-	ChatID       int64
-	APIString    string
+	SendTelegram      bool
+	ChatID            int64
+	APIString         string
+	EnableBot         bool
+	AuthorizedChatIDs []int64
+	Chats             []*TelegramChat
+	DefaultChats      []string
+	TagChats          map[string][]string
+}
+
+// TelegramChat is a private chat, a group or a channel, by ChatID or by
+// Username like "@mychannel". ThreadID is the topic in a group with topics.
+type TelegramChat struct {
+	Name     string
+	ChatID   int64
+	Username string
+	ThreadID int
+}
+
+type Http struct {
+	Enabled   bool
+	Address   string
+	Token     string
+	PublicURL string
+}
+
+type Retry struct {
+	MaxAttempts  int
+	BaseDelaySec int
+	MaxDelaySec  int
 }
 
+// Relay sends the mails to To, Cc and Bcc. EmailTarget is the single recipient
+// of the first versions, it is still added to To. An event can be sent to one
+// of the Groups instead. Security and Auth are described in transport.go.
 type Relay struct {
 	SendMail    bool
 	MailFrom    string
@@ -31,32 +119,161 @@ type Relay struct {
 	Host        string
 	User        string
 	EmailTarget string
+	Recipients
+	Groups             map[string]*Recipients
+	Security           string
+	Auth               string
+	CAFile             string
+	InsecureSkipVerify bool
 }
 
-var Current = &Config{}
+const legacySelector = "body > main > section.event-hero.bg-mono-darkest.color-brand-primary > div.event-hero__content > div > div > div:nth-child(1) > div > div.event-hero__buttons.mt-5 > p"
+
+var current atomic.Pointer[Config]
+
+func init() {
+	current.Store(&Config{})
+}
+
+// Current returns the config in use. A reload replaces it as a whole, so the
+// web server and the bot can read it while the scheduler swaps it.
+func Current() *Config {
+	return current.Load()
+}
+
+// SetCurrent replaces the config in use
+func SetCurrent(cfg *Config) {
+	current.Store(cfg)
+}
 
 func ReadConfig(configfile string) (*Config, error) {
+	cfg, err := Load(configfile)
+	if err != nil {
+		return nil, err
+	}
+	SetCurrent(cfg)
+	log.Println("Configuration: ", cfg.Relay.MailFrom, cfg.Relay.Host, cfg.Relay.MailFrom, cfg.Telegram.SendTelegram)
+	return cfg, nil
+}
+
+// Load reads the config file with its custom override in a new Config,
+// Current is not changed
+func Load(configfile string) (*Config, error) {
 	_, err := os.Stat(configfile)
 	if err != nil {
 		return nil, err
 	}
-	if _, err := toml.DecodeFile(configfile, &Current); err != nil {
+	cfg := &Config{}
+	if _, err := toml.DecodeFile(configfile, cfg); err != nil {
 		return nil, err
 	}
-	if err := readCustomOverrideConfig(Current, configfile); err != nil {
+	if err := readCustomOverrideConfig(cfg, configfile); err != nil {
+		return nil, err
+	}
+	if cfg.Relay == nil {
+		cfg.Relay = &Relay{}
+	}
+	if cfg.Telegram == nil {
+		cfg.Telegram = &Telegram{}
+	}
+	if cfg.JournalFileName == "" {
+		cfg.JournalFileName = "journal.json"
+	}
+	if cfg.WatchStateFile == "" {
+		cfg.WatchStateFile = "watch-state.json"
+	}
+	if cfg.Http == nil {
+		cfg.Http = &Http{}
+	}
+	if cfg.Http.Address == "" {
+		cfg.Http.Address = "127.0.0.1:8081"
+	}
+	if cfg.Http.PublicURL == "" {
+		cfg.Http.PublicURL = "http://" + cfg.Http.Address
+	}
+	if cfg.Retry == nil {
+		cfg.Retry = &Retry{}
+	}
+	if cfg.Retry.MaxAttempts <= 0 {
+		cfg.Retry.MaxAttempts = 5
+	}
+	if cfg.Retry.BaseDelaySec <= 0 {
+		cfg.Retry.BaseDelaySec = 60
+	}
+	if cfg.Retry.MaxDelaySec <= 0 {
+		cfg.Retry.MaxDelaySec = 3600
+	}
+	if err := cfg.Relay.parseTransport(); err != nil {
 		return nil, err
 	}
 
-	log.Println("Configuration: ", Current.Relay.MailFrom, Current.Relay.Host, Current.Relay.MailFrom, Current.Telegram.SendTelegram)
-	return Current, nil
+	if err := cfg.parseClock(); err != nil {
+		return nil, err
+	}
+	cfg.legacyWatch()
+	return cfg, nil
 }
 
-func readCustomOverrideConfig(Current *Config, configfile string) error {
+// legacyWatch turns UrlToCheck into a watch with the selector and the text
+// of the race page that the first versions checked
+func (cfg *Config) legacyWatch() {
+	url := strings.TrimSpace(cfg.UrlToCheck)
+	if url == "" {
+		return
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		log.Printf("UrlToCheck %q is not a http url, the site is not checked", url)
+		return
+	}
+	for _, w := range cfg.Watch {
+		if w.URL == url {
+			return
+		}
+	}
+	log.Println("UrlToCheck is deprecated, it is checked as a [[Watch]]: ", url)
+	cfg.Watch = append(cfg.Watch, &Watch{
+		Name:     "UrlToCheck",
+		URL:      url,
+		Selector: legacySelector,
+		Mode:     "not-contains",
+		Pattern:  "Check back soon for entry details on this race",
+	})
+}
+
+// EscalationFor returns the policy by name or, when name is empty, the
+// first one declared for the event type. Nil if none applies.
+func (cfg *Config) EscalationFor(name, eventType string) *Escalation {
+	for _, esc := range cfg.Escalation {
+		if name != "" {
+			if esc.Name == name {
+				return esc
+			}
+			continue
+		}
+		for _, tt := range esc.Types {
+			if tt == eventType {
+				return esc
+			}
+		}
+	}
+	return nil
+}
+
+// Files returns the config file and its custom override, also when it does not exist
+func Files(configfile string) []string {
+	return []string{configfile, customFileName(configfile)}
+}
+
+func customFileName(configfile string) string {
 	base := path.Base(configfile)
 	dd := path.Dir(configfile)
 	ext := path.Ext(configfile)
 	cf := strings.Replace(base, ext, "_custom.toml", 1)
-	cf_ful := path.Join(dd, cf)
+	return path.Join(dd, cf)
+}
+
+func readCustomOverrideConfig(Current *Config, configfile string) error {
+	cf_ful := customFileName(configfile)
 	log.Println("Check for custom config ", cf_ful)
 	if _, err := os.Stat(cf_ful); err != nil {
 		log.Println("No custom config file found")
@@ -0,0 +1,69 @@
+package conf
+
+import (
+	"log"
+	"strings"
+)
+
+// Recipients of a mail, the envelope has one RCPT for each address
+type Recipients struct {
+	To  []string
+	Cc  []string
+	Bcc []string
+}
+
+// All returns the addresses of To, Cc and Bcc without duplicates
+func (rc Recipients) All() []string {
+	res := make([]string, 0, len(rc.To)+len(rc.Cc)+len(rc.Bcc))
+	seen := make(map[string]bool)
+	for _, list := range [][]string{rc.To, rc.Cc, rc.Bcc} {
+		for _, addr := range list {
+			if key := strings.ToLower(addr); !seen[key] {
+				seen[key] = true
+				res = append(res, addr)
+			}
+		}
+	}
+	return res
+}
+
+func (rc Recipients) Empty() bool {
+	return len(rc.To)+len(rc.Cc)+len(rc.Bcc) == 0
+}
+
+// Key is the same for the same recipients, used to send a single mail to them
+func (rc Recipients) Key() string {
+	return strings.Join(rc.To, ",") + "|" + strings.Join(rc.Cc, ",") + "|" + strings.Join(rc.Bcc, ",")
+}
+
+// DefaultRecipients are the recipients of the mails that are not routed to a group
+func (r *Relay) DefaultRecipients() Recipients {
+	rc := Recipients{Cc: r.Cc, Bcc: r.Bcc}
+	if r.EmailTarget != "" {
+		rc.To = append(rc.To, r.EmailTarget)
+	}
+	rc.To = append(rc.To, r.To...)
+	return rc
+}
+
+// RecipientsFor returns the recipients of an event with the group and the
+// addresses of its data entry. The addresses are added to To of the group.
+// Without both, or with a group not in the config, the default recipients are used.
+func (r *Relay) RecipientsFor(group string, addresses []string) Recipients {
+	rc := Recipients{}
+	if group != "" {
+		if g, ok := r.Groups[group]; ok {
+			rc = Recipients{To: g.To, Cc: g.Cc, Bcc: g.Bcc}
+		} else {
+			log.Printf("mail group %q not found, use the default recipients", group)
+			return r.DefaultRecipients()
+		}
+	}
+	if len(addresses) > 0 {
+		rc.To = append(append([]string{}, rc.To...), addresses...)
+	}
+	if rc.Empty() {
+		return r.DefaultRecipients()
+	}
+	return rc
+}
@@ -0,0 +1,62 @@
+package conf
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Security of the connection to the relay
+const (
+	// SecurityTLS is the implicit TLS of the submission port 465
+	SecurityTLS = "tls"
+	// SecurityStartTLS upgrades the connection with STARTTLS, port 587
+	SecurityStartTLS = "starttls"
+	// SecurityNone is a plain connection, for a local relay on port 25
+	SecurityNone = "none"
+)
+
+// Authentication methods of the relay
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCramMD5 = "cram-md5"
+	AuthNone    = "none"
+)
+
+// parseTransport sets the defaults of Security and Auth and checks them.
+// Security defaults on the port of Host: starttls for 587, none for 25,
+// otherwise tls. Auth defaults to plain with a User, none without.
+func (r *Relay) parseTransport() error {
+	r.Security = strings.ToLower(strings.TrimSpace(r.Security))
+	if r.Security == "" {
+		_, port, _ := net.SplitHostPort(r.Host)
+		switch port {
+		case "587":
+			r.Security = SecurityStartTLS
+		case "25":
+			r.Security = SecurityNone
+		default:
+			r.Security = SecurityTLS
+		}
+	}
+	switch r.Security {
+	case SecurityTLS, SecurityStartTLS, SecurityNone:
+	default:
+		return fmt.Errorf("relay Security %q not recognized, use tls, starttls or none", r.Security)
+	}
+
+	r.Auth = strings.ToLower(strings.TrimSpace(r.Auth))
+	if r.Auth == "" {
+		r.Auth = AuthNone
+		if r.User != "" {
+			r.Auth = AuthPlain
+		}
+	}
+	switch r.Auth {
+	case AuthPlain, AuthLogin, AuthCramMD5, AuthNone:
+	default:
+		return fmt.Errorf("relay Auth %q not recognized, use plain, login, cram-md5 or none", r.Auth)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package datafile
+
+import (
+	"birthsch/idl"
+	"birthsch/jsonfile"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+func Read(fname string) (*idl.SchedList, error) {
+	log.Println("load scheduler json data ", fname)
+	if fname == "" {
+		return nil, fmt.Errorf("data file is empty")
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	schList := idl.SchedList{}
+
+	err = json.NewDecoder(f).Decode(&schList)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Loaded scheduler from file ", fname, schList)
+	return &schList, nil
+}
+
+// Write replaces the data file atomically, with the same indentation of data.json_example
+func Write(fname string, schList *idl.SchedList) error {
+	if fname == "" {
+		return fmt.Errorf("data file is empty")
+	}
+	if err := jsonfile.WriteAtomicIndent(fname, schList, "    "); err != nil {
+		return err
+	}
+	log.Println("Saved scheduler data file ", fname, len(schList.List))
+	return nil
+}
+
+// Store serializes the changes to the data file done by the service and keeps
+// the last valid content, so that an invalid edit of the file is not used
+type Store struct {
+	fname string
+	mu    sync.Mutex
+	list  *idl.SchedList
+}
+
+func NewStore(fname string) *Store {
+	return &Store{fname: fname}
+}
+
+func (st *Store) FileName() string {
+	return st.fname
+}
+
+// Load returns a copy of the last valid list, the file is read on the first call
+func (st *Store) Load() (*idl.SchedList, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.list == nil {
+		if err := st.reload(); err != nil {
+			return nil, err
+		}
+	}
+	return clone(st.list), nil
+}
+
+// Reload reads the file again. When the new content is not valid the error is
+// returned and the last valid list is kept.
+func (st *Store) Reload() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.reload()
+}
+
+func (st *Store) reload() error {
+	schList, err := Read(st.fname)
+	if err == nil {
+		err = schList.Validate()
+	}
+	if err != nil {
+		return fmt.Errorf("data file %s is not valid: %v", st.fname, err)
+	}
+	st.list = schList
+	return nil
+}
+
+// Update applies fn to the current list and writes it back only when the result is valid
+func (st *Store) Update(fn func(schList *idl.SchedList) error) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	schList, err := Read(st.fname)
+	if err != nil {
+		return err
+	}
+	if err := fn(schList); err != nil {
+		return err
+	}
+	if err := schList.Validate(); err != nil {
+		return err
+	}
+	if err := Write(st.fname, schList); err != nil {
+		return err
+	}
+	st.list = clone(schList)
+	return nil
+}
+
+func clone(schList *idl.SchedList) *idl.SchedList {
+	res := &idl.SchedList{List: make([]idl.SchedItem, len(schList.List))}
+	for i, item := range schList.List {
+		if item.LeadDays != nil {
+			item.LeadDays = append([]int{}, item.LeadDays...)
+		}
+		if item.Recipients != nil {
+			item.Recipients = append([]string{}, item.Recipients...)
+		}
+		if item.Chats != nil {
+			item.Chats = append([]string{}, item.Chats...)
+		}
+		if item.Tags != nil {
+			item.Tags = append([]string{}, item.Tags...)
+		}
+		res.List[i] = item
+	}
+	return res
+}
@@ -0,0 +1,170 @@
+package ical
+
+import (
+	"birthsch/idl"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Events without a known year start in a leap year, so that Feb-29 is valid
+const defaultYear = 2000
+
+// Custom property with the year of birth or wedding, the DTSTART year is not reliable for that
+const propYear = "X-BIRTHSCH-YEAR"
+
+type ExportOptions struct {
+	LeadDays []int
+	// AlarmClock, when set, gives hour and minute of the alarm per event type (Compl, Anniv)
+	AlarmClock func(eventType string) (int, int)
+}
+
+// Export writes the scheduled events as an RFC 5545 calendar with yearly recurring events.
+// Every lead day of an event becomes a VALARM at the alarm hour.
+func Export(w io.Writer, schList *idl.SchedList, opt *ExportOptions) error {
+	bw := bufio.NewWriter(w)
+	cw := &contentWriter{w: bw}
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	cw.line("BEGIN:VCALENDAR")
+	cw.line("VERSION:2.0")
+	cw.line(fmt.Sprintf("PRODID:-//%s//%s//EN", idl.Appname, idl.Buildnr))
+	cw.line("CALSCALE:GREGORIAN")
+	cw.line("X-WR-CALNAME:" + escapeText(idl.Appname))
+	for _, item := range schList.List {
+		mm, dd, yy, err := item.ParseDate()
+		if err != nil {
+			return fmt.Errorf("%s: %v", item.Name, err)
+		}
+		sni := idl.SchedNextItem{}
+		if err := sni.SetEventType(item.Type); err != nil {
+			return fmt.Errorf("%s: %v", item.Name, err)
+		}
+		startYear := yy
+		if startYear == 0 {
+			startYear = defaultYear
+		}
+		start := time.Date(startYear, mm, dd, 0, 0, 0, 0, time.UTC)
+		summary := "Birthday: " + item.Name
+		category := "BIRTHDAY"
+		if sni.EventType == idl.Anniversary {
+			summary = "Anniversary: " + item.Name
+			category = "ANNIVERSARY"
+		}
+		cw.line("BEGIN:VEVENT")
+		cw.line("UID:" + eventUID(&item))
+		cw.line("DTSTAMP:" + stamp)
+		cw.line("DTSTART;VALUE=DATE:" + start.Format("20060102"))
+		cw.line("DTEND;VALUE=DATE:" + start.AddDate(0, 0, 1).Format("20060102"))
+		if mm == time.February && dd == 29 {
+			cw.line("RRULE:FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=-1")
+		} else {
+			cw.line("RRULE:FREQ=YEARLY")
+		}
+		cw.line("SUMMARY:" + escapeText(summary))
+		description := item.Note
+		if yy != 0 {
+			description = strings.TrimSpace(fmt.Sprintf("%s (%d)", item.Note, yy))
+		}
+		if description != "" {
+			cw.line("DESCRIPTION:" + escapeText(description))
+		}
+		cw.line("CATEGORIES:" + category)
+		if yy != 0 {
+			cw.line(fmt.Sprintf("%s:%d", propYear, yy))
+		}
+		cw.line("TRANSP:TRANSPARENT")
+		for _, lead := range item.EffectiveLeadDays(opt.LeadDays) {
+			offset := alarmOffset(opt, item.Type) - time.Duration(lead)*24*time.Hour
+			cw.line("BEGIN:VALARM")
+			cw.line("ACTION:DISPLAY")
+			cw.line("DESCRIPTION:" + escapeText(summary))
+			cw.line("TRIGGER:" + formatDuration(offset))
+			cw.line("END:VALARM")
+		}
+		cw.line("END:VEVENT")
+	}
+	cw.line("END:VCALENDAR")
+	if cw.err != nil {
+		return cw.err
+	}
+	return bw.Flush()
+}
+
+func eventUID(item *idl.SchedItem) string {
+	sum := sha1.Sum([]byte(item.Name + "|" + item.Type + "|" + item.MonthDay))
+	return hex.EncodeToString(sum[:10]) + "@" + idl.Appname
+}
+
+// alarmOffset is the time of the alarm after the start of the event day,
+// midnight without AlarmClock
+func alarmOffset(opt *ExportOptions, eventType string) time.Duration {
+	if opt.AlarmClock == nil {
+		return 0
+	}
+	hh, mm := opt.AlarmClock(eventType)
+	return time.Duration(hh)*time.Hour + time.Duration(mm)*time.Minute
+}
+
+// formatDuration returns the RFC 5545 duration, e.g. -P6DT15H
+func formatDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	days := int(d / (24 * time.Hour))
+	d -= time.Duration(days) * 24 * time.Hour
+	hours := int(d / time.Hour)
+	d -= time.Duration(hours) * time.Hour
+	minutes := int(d / time.Minute)
+
+	res := sign + "P"
+	if days > 0 {
+		res += fmt.Sprintf("%dD", days)
+	}
+	if hours > 0 || minutes > 0 || days == 0 {
+		res += "T"
+		if hours > 0 || minutes == 0 {
+			res += fmt.Sprintf("%dH", hours)
+		}
+		if minutes > 0 {
+			res += fmt.Sprintf("%dM", minutes)
+		}
+	}
+	return res
+}
+
+func escapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+type contentWriter struct {
+	w   io.Writer
+	err error
+}
+
+// line writes a content line folded at 75 octets, without splitting utf-8 characters
+func (cw *contentWriter) line(s string) {
+	if cw.err != nil {
+		return
+	}
+	var sb strings.Builder
+	lineLen := 0
+	for _, r := range s {
+		rl := len(string(r))
+		if lineLen+rl > 75 {
+			sb.WriteString("\r\n ")
+			lineLen = 1
+		}
+		sb.WriteRune(r)
+		lineLen += rl
+	}
+	sb.WriteString("\r\n")
+	_, cw.err = io.WriteString(cw.w, sb.String())
+}
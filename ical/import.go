@@ -0,0 +1,145 @@
+package ical
+
+import (
+	"birthsch/idl"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Event struct {
+	Summary     string
+	Description string
+	Categories  string
+	Start       time.Time
+	Yearly      bool
+	Year        int
+}
+
+// Parse reads all the VEVENT components of an RFC 5545 calendar
+func Parse(r io.Reader) ([]*Event, error) {
+	lines, err := unfoldLines(r)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*Event, 0)
+	var ev *Event
+	// properties of VALARM and other nested components are not for the event
+	nested := 0
+	for i, line := range lines {
+		name, params, value := splitContentLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			ev = &Event{}
+			nested = 0
+		case name == "END" && value == "VEVENT":
+			if ev != nil {
+				res = append(res, ev)
+			}
+			ev = nil
+		case ev == nil:
+			continue
+		case name == "BEGIN":
+			nested++
+		case name == "END":
+			nested--
+		case nested > 0:
+			continue
+		case name == "SUMMARY":
+			ev.Summary = unescapeText(value)
+		case name == "DESCRIPTION":
+			ev.Description = unescapeText(value)
+		case name == "CATEGORIES":
+			ev.Categories = strings.ToUpper(unescapeText(value))
+		case name == "RRULE":
+			ev.Yearly = strings.Contains(strings.ToUpper(value), "FREQ=YEARLY")
+		case name == propYear:
+			if ev.Year, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s %q", i+1, propYear, value)
+			}
+		case name == "DTSTART":
+			if ev.Start, err = parseDate(value); err != nil {
+				return nil, fmt.Errorf("line %d: invalid DTSTART%s %q", i+1, params, value)
+			}
+		}
+	}
+	return res, nil
+}
+
+// ToSchedItem converts a yearly event, the summary prefix of the export sets the type
+func (ev *Event) ToSchedItem() (*idl.SchedItem, error) {
+	if !ev.Yearly {
+		return nil, fmt.Errorf("event %q is not yearly", ev.Summary)
+	}
+	if ev.Start.IsZero() {
+		return nil, fmt.Errorf("event %q has no start date", ev.Summary)
+	}
+	item := &idl.SchedItem{
+		Name:     strings.TrimSpace(ev.Summary),
+		MonthDay: fmt.Sprintf("%s-%02d", idl.MonthToString(ev.Start.Month()), ev.Start.Day()),
+		Type:     idl.Birthday.String(),
+		Note:     strings.TrimSpace(ev.Description),
+		Year:     ev.Year,
+	}
+	switch {
+	case strings.HasPrefix(item.Name, "Birthday:"):
+		item.Name = strings.TrimSpace(strings.TrimPrefix(item.Name, "Birthday:"))
+	case strings.HasPrefix(item.Name, "Anniversary:"):
+		item.Name = strings.TrimSpace(strings.TrimPrefix(item.Name, "Anniversary:"))
+		item.Type = idl.Anniversary.String()
+	case strings.Contains(ev.Categories, "ANNIVERSARY"):
+		item.Type = idl.Anniversary.String()
+	}
+	if item.Year != 0 {
+		// the export appends the year to the note
+		item.Note = strings.TrimSpace(strings.TrimSuffix(item.Note, fmt.Sprintf("(%d)", item.Year)))
+	}
+	if item.Name == "" {
+		return nil, fmt.Errorf("event on %s has no summary", item.MonthDay)
+	}
+	return item, nil
+}
+
+func unfoldLines(r io.Reader) ([]string, error) {
+	res := []string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(res) > 0 {
+			res[len(res)-1] += line[1:]
+			continue
+		}
+		res = append(res, line)
+	}
+	return res, scanner.Err()
+}
+
+// splitContentLine splits "NAME;PARAM=x:value" in upper case name, params and value
+func splitContentLine(line string) (string, string, string) {
+	ix := strings.Index(line, ":")
+	if ix < 0 {
+		return strings.ToUpper(line), "", ""
+	}
+	name, value := line[:ix], line[ix+1:]
+	params := ""
+	if ip := strings.Index(name, ";"); ip >= 0 {
+		name, params = name[:ip], name[ip:]
+	}
+	return strings.ToUpper(name), params, value
+}
+
+func parseDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("date too short")
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+func unescapeText(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
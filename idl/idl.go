@@ -1,7 +1,13 @@
 package idl
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -10,11 +16,23 @@ var (
 	Buildnr = "00.004.20250127-00"
 )
 
+var (
+	BirthdayMilestones    = []int{18, 30, 40, 50, 60, 70, 80, 90, 100}
+	AnniversaryMilestones = []int{1, 10, 20, 25, 30, 40, 50, 60}
+)
+
 type SchedItem struct {
-	Name     string
-	MonthDay string
-	Type     string
-	Note     string
+	Name       string
+	MonthDay   string
+	Type       string
+	Note       string
+	Year       int      `json:",omitempty"`
+	LeadDays   []int    `json:",omitempty"`
+	Escalation string   `json:",omitempty"`
+	Group      string   `json:",omitempty"`
+	Recipients []string `json:",omitempty"`
+	Chats      []string `json:",omitempty"`
+	Tags       []string `json:",omitempty"`
 }
 
 type SchedList struct {
@@ -29,10 +47,57 @@ const (
 )
 
 type SchedNextItem struct {
-	Name      string
-	Time      time.Time
-	EventType EventType
-	Note      string
+	// EventID of the item in the data file
+	ID         string `json:",omitempty"`
+	Name       string
+	Time       time.Time
+	EventType  EventType
+	Note       string
+	DaysLeft   int
+	Years      int
+	Milestone  bool
+	Escalation string   `json:",omitempty"`
+	Group      string   `json:",omitempty"`
+	Recipients []string `json:",omitempty"`
+	Chats      []string `json:",omitempty"`
+	Tags       []string `json:",omitempty"`
+}
+
+type WebChange struct {
+	Name string
+	URL  string
+	Text string
+	Diff string
+}
+
+type WatchStatus struct {
+	Name          string
+	URL           string
+	Status        string
+	CheckedAt     time.Time
+	TriggeredAt   time.Time
+	CooldownUntil time.Time
+}
+
+// Digest is the summary of the events from From to To, grouped by day
+type Digest struct {
+	Title string
+	From  time.Time
+	To    time.Time
+	Count int
+	Days  []*DigestDay
+}
+
+type DigestDay struct {
+	Day   time.Time
+	Items []*SchedNextItem
+}
+
+type DeliveryFailed struct {
+	Channel     string
+	Attempts    int
+	Error       string
+	Description string
 }
 
 func (sni *SchedNextItem) SetEventType(tt string) error {
@@ -46,3 +111,290 @@ func (sni *SchedNextItem) SetEventType(tt string) error {
 	}
 	return fmt.Errorf("type %s not recognized", tt)
 }
+
+// SetYears computes the age or the anniversary count at the time of the event.
+// A zero year means that the year is not known.
+func (sni *SchedNextItem) SetYears(yy int) {
+	sni.Years = 0
+	sni.Milestone = false
+	if yy == 0 || sni.Time.Year() <= yy {
+		return
+	}
+	sni.Years = sni.Time.Year() - yy
+	milestones := BirthdayMilestones
+	if sni.EventType == Anniversary {
+		milestones = AnniversaryMilestones
+	}
+	for _, m := range milestones {
+		if m == sni.Years {
+			sni.Milestone = true
+			break
+		}
+	}
+}
+
+// NextItem returns the next occurrence of the item, starting from the day of from
+func (si *SchedItem) NextItem(from time.Time) (*SchedNextItem, error) {
+	mm, dd, yy, err := si.ParseDate()
+	if err != nil {
+		return nil, err
+	}
+	occurrence := NextOccurrence(mm, dd, from)
+	time_item := time.Date(occurrence.Year(), occurrence.Month(), occurrence.Day(), 23, 59, 0, 0, from.Location())
+	res := &SchedNextItem{ID: si.EventID(), Name: si.Name, Note: si.Note, Time: time_item, DaysLeft: DaysBetween(from, time_item), Escalation: si.Escalation,
+		Group: si.Group, Recipients: si.Recipients, Chats: si.Chats, Tags: si.Tags}
+	if err := res.SetEventType(si.Type); err != nil {
+		return nil, err
+	}
+	res.SetYears(yy)
+	return res, nil
+}
+
+// EventID identifies the event by name, type and day. It does not change when other
+// events are added or removed, nor when the note or the year are edited.
+func (si *SchedItem) EventID() string {
+	day := si.MonthDay
+	if mm, dd, _, err := si.ParseDate(); err == nil {
+		day = fmt.Sprintf("%02d-%02d", int(mm), dd)
+	}
+	sum := sha1.Sum([]byte(si.Name + "|" + si.Type + "|" + day))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Index returns the position of the event with the ID, -1 when it is not in the list
+func (sl *SchedList) Index(id string) int {
+	for i := range sl.List {
+		if sl.List[i].EventID() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (si *SchedItem) Validate() error {
+	if strings.TrimSpace(si.Name) == "" {
+		return fmt.Errorf("name is empty")
+	}
+	if _, _, _, err := si.ParseDate(); err != nil {
+		return err
+	}
+	if err := (&SchedNextItem{}).SetEventType(si.Type); err != nil {
+		return err
+	}
+	for _, lead := range si.LeadDays {
+		if lead < 0 {
+			return fmt.Errorf("lead days %d is negative", lead)
+		}
+	}
+	for _, addr := range si.Recipients {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("recipient %q: %v", addr, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks all the items and reports the index of the first invalid one
+func (sl *SchedList) Validate() error {
+	if errs := sl.Errors(); len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Errors validates all the entries and returns an error for each invalid one
+func (sl *SchedList) Errors() []error {
+	res := make([]error, 0)
+	for i := range sl.List {
+		if err := sl.List[i].Validate(); err != nil {
+			res = append(res, fmt.Errorf("entry %d (%s): %v", i, sl.List[i].Name, err))
+		}
+	}
+	return res
+}
+
+// Reminders returns the reminders that fall on the day of now, one for
+// each item with a lead time matching the days left to its event
+func (sl *SchedList) Reminders(now time.Time, globalLeads []int) ([]*SchedNextItem, error) {
+	res := make([]*SchedNextItem, 0)
+	for i := range sl.List {
+		item := &sl.List[i]
+		eventItem, err := item.NextItem(now)
+		if err != nil {
+			return nil, err
+		}
+		for _, lead := range item.EffectiveLeadDays(globalLeads) {
+			if lead == eventItem.DaysLeft {
+				nextItem := *eventItem
+				res = append(res, &nextItem)
+			}
+		}
+	}
+	return res, nil
+}
+
+// Upcoming returns the events of the next days, sorted by date
+func (sl *SchedList) Upcoming(from time.Time, days int) ([]*SchedNextItem, error) {
+	res := make([]*SchedNextItem, 0)
+	for i := range sl.List {
+		next, err := sl.List[i].NextItem(from)
+		if err != nil {
+			return nil, err
+		}
+		if next.DaysLeft < days {
+			res = append(res, next)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Time.Before(res[j].Time)
+	})
+	return res, nil
+}
+
+// EffectiveLeadDays returns the lead days of the item, or the global ones when not set
+func (si *SchedItem) EffectiveLeadDays(global []int) []int {
+	if len(si.LeadDays) > 0 {
+		return si.LeadDays
+	}
+	if len(global) > 0 {
+		return global
+	}
+	return []int{0}
+}
+
+// ParseDate parses MonthDay in the format "Gen-03" or "Gen-03-1985".
+// The year in MonthDay has precedence over the Year field, zero is for no year.
+func (si *SchedItem) ParseDate() (time.Month, int, int, error) {
+	tmp_arr := strings.Split(si.MonthDay, "-")
+	if len(tmp_arr) != 2 && len(tmp_arr) != 3 {
+		return 0, 0, 0, fmt.Errorf("expect month-day format, but get %s", si.MonthDay)
+	}
+	mm, err := MonthFromString(tmp_arr[0])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	dd, err := strconv.Atoi(tmp_arr[1])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	// a leap year, so that Feb-29 is valid for the leap day births
+	if dd < 1 || dd > daysIn(mm, 2000) {
+		return 0, 0, 0, fmt.Errorf("day %d out of range in %s", dd, si.MonthDay)
+	}
+	yy := si.Year
+	if len(tmp_arr) == 3 {
+		if yy, err = strconv.Atoi(tmp_arr[2]); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+	if yy != 0 && (yy < 1800 || yy > 9999) {
+		return 0, 0, 0, fmt.Errorf("year %d out of range in %s", yy, si.Name)
+	}
+	if yy != 0 && dd > daysIn(mm, yy) {
+		return 0, 0, 0, fmt.Errorf("%s %d has no day %d", MonthToString(mm), yy, dd)
+	}
+	return mm, dd, yy, nil
+}
+
+// daysIn returns the number of days of the month in the year
+func daysIn(mm time.Month, yy int) int {
+	return time.Date(yy, mm+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func MonthFromString(s string) (time.Month, error) {
+	switch s {
+	case "Gen":
+		return time.Month(1), nil
+	case "Feb":
+		return time.Month(2), nil
+	case "Mar":
+		return time.Month(3), nil
+	case "Apr":
+		return time.Month(4), nil
+	case "Mag":
+		return time.Month(5), nil
+	case "Giu":
+		return time.Month(6), nil
+	case "Lug":
+		return time.Month(7), nil
+	case "Ago":
+		return time.Month(8), nil
+	case "Set":
+		return time.Month(9), nil
+	case "Ott":
+		return time.Month(10), nil
+	case "Nov":
+		return time.Month(11), nil
+	case "Dic":
+		return time.Month(12), nil
+
+	}
+	return 0, fmt.Errorf("month not recongnized %s", s)
+}
+
+func MonthToString(mm time.Month) string {
+	names := []string{"Gen", "Feb", "Mar", "Apr", "Mag", "Giu", "Lug", "Ago", "Set", "Ott", "Nov", "Dic"}
+	if mm < 1 || mm > 12 {
+		return ""
+	}
+	return names[mm-1]
+}
+
+func (et EventType) String() string {
+	switch et {
+	case Birthday:
+		return "Compl"
+	case Anniversary:
+		return "Anniv"
+	}
+	return fmt.Sprintf("EventType(%d)", int(et))
+}
+
+// Key identifies the item in the delivery journal. The ID keeps apart the
+// events with the same name and type on different days.
+func (sni *SchedNextItem) Key() string {
+	return fmt.Sprintf("%s/%s/%s", sni.Name, sni.EventType, sni.ID)
+}
+
+// Occurrence is the date of the event as stored in the delivery journal.
+// Advance reminders get the lead time as suffix, so that each one is delivered once.
+func (sni *SchedNextItem) Occurrence() string {
+	if sni.DaysLeft > 0 {
+		return fmt.Sprintf("%s-%dd", sni.Time.Format("2006-01-02"), sni.DaysLeft)
+	}
+	return sni.Time.Format("2006-01-02")
+}
+
+// NextOccurrence returns the first date, starting from the day of from, that
+// falls on the given month and day
+func NextOccurrence(mm time.Month, dd int, from time.Time) time.Time {
+	yy := from.Year()
+	today := time.Date(yy, from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	res := time.Date(yy, mm, dd, 0, 0, 0, 0, from.Location())
+	if res.Before(today) {
+		res = time.Date(yy+1, mm, dd, 0, 0, 0, 0, from.Location())
+	}
+	return res
+}
+
+// DaysBetween counts the calendar days from the day of t1 to the day of t2
+func DaysBetween(t1, t2 time.Time) int {
+	d1 := time.Date(t1.Year(), t1.Month(), t1.Day(), 0, 0, 0, 0, time.UTC)
+	d2 := time.Date(t2.Year(), t2.Month(), t2.Day(), 0, 0, 0, 0, time.UTC)
+	return int(d2.Sub(d1).Hours() / 24)
+}
+
+// GroupByDay groups the items, sorted by time, on the day of their occurrence
+func GroupByDay(items []*SchedNextItem) []*DigestDay {
+	res := make([]*DigestDay, 0)
+	var last *DigestDay
+	for _, item := range items {
+		if last == nil || DaysBetween(last.Day, item.Time) != 0 {
+			day := time.Date(item.Time.Year(), item.Time.Month(), item.Time.Day(), 0, 0, 0, 0, item.Time.Location())
+			last = &DigestDay{Day: day}
+			res = append(res, last)
+		}
+		last.Items = append(last.Items, item)
+	}
+	return res
+}
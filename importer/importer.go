@@ -0,0 +1,143 @@
+package importer
+
+import (
+	"birthsch/ical"
+	"birthsch/idl"
+	"birthsch/vcard"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ActionAdded   = "add"
+	ActionChanged = "change"
+	ActionSkipped = "skip"
+)
+
+type ReportEntry struct {
+	Action string
+	Source string
+	Item   idl.SchedItem
+	Reason string
+}
+
+func (re *ReportEntry) String() string {
+	res := fmt.Sprintf("%-6s %s %s %s", re.Action, re.Item.MonthDay, re.Item.Type, re.Item.Name)
+	if re.Item.Year != 0 {
+		res += fmt.Sprintf(" (%d)", re.Item.Year)
+	}
+	if re.Reason != "" {
+		res += ": " + re.Reason
+	}
+	return res + " [" + re.Source + "]"
+}
+
+// ReadFile returns the items of an .ics or .vcf file. Entries that can't be converted
+// are returned as skipped report entries.
+func ReadFile(fname string) ([]*idl.SchedItem, []*ReportEntry, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	source := filepath.Base(fname)
+	items := make([]*idl.SchedItem, 0)
+	skipped := make([]*ReportEntry, 0)
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".ics":
+		events, err := ical.Parse(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", fname, err)
+		}
+		for _, ev := range events {
+			item, err := ev.ToSchedItem()
+			if err != nil {
+				skipped = append(skipped, &ReportEntry{Action: ActionSkipped, Source: source,
+					Item: idl.SchedItem{Name: ev.Summary}, Reason: err.Error()})
+				continue
+			}
+			items = append(items, item)
+		}
+	case ".vcf":
+		cards, err := vcard.Parse(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", fname, err)
+		}
+		for _, card := range cards {
+			cardItems, err := card.ToSchedItems()
+			if err != nil {
+				skipped = append(skipped, &ReportEntry{Action: ActionSkipped, Source: source,
+					Item: idl.SchedItem{Name: card.Name}, Reason: err.Error()})
+				continue
+			}
+			items = append(items, cardItems...)
+		}
+	default:
+		return nil, nil, fmt.Errorf("file %s is not .ics or .vcf", fname)
+	}
+	return items, skipped, nil
+}
+
+// Merge adds the items to the list. Items are the same when name and date match,
+// in this case only the missing Year and Note are filled in.
+func Merge(schList *idl.SchedList, items []*idl.SchedItem, source string) ([]*ReportEntry, error) {
+	res := make([]*ReportEntry, 0)
+	for _, item := range items {
+		key, err := itemKey(item)
+		if err != nil {
+			res = append(res, &ReportEntry{Action: ActionSkipped, Source: source, Item: *item, Reason: err.Error()})
+			continue
+		}
+		found := false
+		for i := range schList.List {
+			current := &schList.List[i]
+			curKey, err := itemKey(current)
+			if err != nil {
+				return nil, fmt.Errorf("data file entry %d: %v", i, err)
+			}
+			if curKey != key {
+				continue
+			}
+			found = true
+			entry := &ReportEntry{Action: ActionSkipped, Source: source, Item: *item, Reason: "already present"}
+			if current.Type != item.Type {
+				entry.Reason = fmt.Sprintf("already present with type %s", current.Type)
+				res = append(res, entry)
+				break
+			}
+			changes := []string{}
+			_, _, curYear, _ := current.ParseDate()
+			if curYear == 0 && item.Year != 0 {
+				current.Year = item.Year
+				changes = append(changes, "year")
+			}
+			if current.Note == "" && item.Note != "" {
+				current.Note = item.Note
+				changes = append(changes, "note")
+			}
+			if len(changes) > 0 {
+				entry.Action = ActionChanged
+				entry.Item = *current
+				entry.Reason = "set " + strings.Join(changes, ", ")
+			}
+			res = append(res, entry)
+			break
+		}
+		if !found {
+			schList.List = append(schList.List, *item)
+			res = append(res, &ReportEntry{Action: ActionAdded, Source: source, Item: *item})
+		}
+	}
+	return res, nil
+}
+
+func itemKey(item *idl.SchedItem) (string, error) {
+	mm, dd, _, err := item.ParseDate()
+	if err != nil {
+		return "", err
+	}
+	name := strings.ToLower(strings.Join(strings.Fields(item.Name), " "))
+	return fmt.Sprintf("%s|%02d-%02d", name, mm, dd), nil
+}
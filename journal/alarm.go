@@ -0,0 +1,182 @@
+package journal
+
+import (
+	"birthsch/idl"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const keepAlarmDays = 7
+
+// Alarm is a sent alarm that can be acknowledged or snoozed. The same ID is
+// shared by the copies sent on each channel and target, an acknowledgement
+// or a snooze applies to all of them.
+type Alarm struct {
+	ID          string
+	Template    string
+	Items       []*idl.SchedNextItem
+	Channel     string
+	Target      string `json:",omitempty"`
+	SentAt      time.Time
+	AckedAt     time.Time
+	SnoozeUntil time.Time
+	ResentAt    time.Time
+	EscalatedAt time.Time
+}
+
+func (al *Alarm) IsAcked() bool {
+	return !al.AckedAt.IsZero()
+}
+
+func (al *Alarm) key() string {
+	return al.ID + "/" + al.Channel + "/" + al.Target
+}
+
+func NewAlarmID() string {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+func (jr *Journal) AddAlarm(al *Alarm) error {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.alarms[al.key()] = al
+	return jr.save()
+}
+
+// Alarm returns the first copy of the alarm
+func (jr *Journal) Alarm(id string) (Alarm, bool) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	copies := jr.copies(id)
+	if len(copies) == 0 {
+		return Alarm{}, false
+	}
+	return *copies[0], true
+}
+
+// copies returns the copies of the alarm sorted by channel and target
+func (jr *Journal) copies(id string) []*Alarm {
+	res := make([]*Alarm, 0, 2)
+	for _, al := range jr.alarms {
+		if al.ID == id {
+			res = append(res, al)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].key() < res[j].key() })
+	return res
+}
+
+// AckAlarm acknowledges all the copies of the alarm
+func (jr *Journal) AckAlarm(id string, now time.Time) (Alarm, error) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	copies := jr.copies(id)
+	if len(copies) == 0 {
+		return Alarm{}, fmt.Errorf("alarm %s not found", id)
+	}
+	for _, al := range copies {
+		if !al.IsAcked() {
+			al.AckedAt = now
+			al.SnoozeUntil = time.Time{}
+		}
+	}
+	return *copies[0], jr.save()
+}
+
+// SnoozeAlarm snoozes all the copies of the alarm
+func (jr *Journal) SnoozeAlarm(id string, until time.Time) (Alarm, error) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	copies := jr.copies(id)
+	if len(copies) == 0 {
+		return Alarm{}, fmt.Errorf("alarm %s not found", id)
+	}
+	if copies[0].IsAcked() {
+		return *copies[0], fmt.Errorf("alarm is already acknowledged")
+	}
+	for _, al := range copies {
+		al.SnoozeUntil = until
+	}
+	return *copies[0], jr.save()
+}
+
+// TakeDueSnoozed returns the snoozed alarms to send again and clears their snooze time
+func (jr *Journal) TakeDueSnoozed(now time.Time) ([]Alarm, error) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	res := make([]Alarm, 0)
+	for _, al := range jr.alarms {
+		if al.IsAcked() || al.SnoozeUntil.IsZero() || now.Before(al.SnoozeUntil) {
+			continue
+		}
+		al.SnoozeUntil = time.Time{}
+		res = append(res, *al)
+	}
+	if len(res) == 0 {
+		return res, nil
+	}
+	return res, jr.save()
+}
+
+// NextSnooze returns the earliest snooze time of the alarms not acknowledged
+func (jr *Journal) NextSnooze() (time.Time, bool) {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	var next time.Time
+	for _, al := range jr.alarms {
+		if al.IsAcked() || al.SnoozeUntil.IsZero() {
+			continue
+		}
+		if next.IsZero() || al.SnoozeUntil.Before(next) {
+			next = al.SnoozeUntil
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// PendingAlarms returns the alarms that are neither acknowledged nor snoozed
+func (jr *Journal) PendingAlarms() []Alarm {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	res := make([]Alarm, 0)
+	for _, al := range jr.alarms {
+		if al.IsAcked() || !al.SnoozeUntil.IsZero() {
+			continue
+		}
+		res = append(res, *al)
+	}
+	return res
+}
+
+// UpdateAlarm changes all the stored copies of the alarm with fn and saves the journal
+func (jr *Journal) UpdateAlarm(id string, fn func(al *Alarm)) error {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	copies := jr.copies(id)
+	if len(copies) == 0 {
+		return fmt.Errorf("alarm %s not found", id)
+	}
+	for _, al := range copies {
+		fn(al)
+	}
+	return jr.save()
+}
+
+// UpdateAlarmCopy changes only the stored copy of al, on its channel and target
+func (jr *Journal) UpdateAlarmCopy(al *Alarm, fn func(al *Alarm)) error {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	stored, ok := jr.alarms[al.key()]
+	if !ok {
+		return fmt.Errorf("alarm %s on %s not found", al.ID, al.Channel)
+	}
+	fn(stored)
+	return jr.save()
+}
@@ -0,0 +1,181 @@
+package journal
+
+import (
+	"birthsch/jsonfile"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	keepDays       = 400
+	historyRecords = 300
+)
+
+const (
+	StatusSent      = "sent"
+	StatusFailed    = "failed"
+	StatusRetry     = "retry"
+	StatusAcked     = "acknowledged"
+	StatusSnoozed   = "snoozed"
+	StatusEscalated = "escalated"
+)
+
+type Entry struct {
+	Item       string
+	Occurrence string
+	Channel    string
+	Time       time.Time
+}
+
+// Record is an attempt to deliver an alarm, kept for the delivery history
+type Record struct {
+	Time        time.Time
+	Channel     string
+	Description string
+	Status      string
+	Attempt     int
+	Error       string `json:",omitempty"`
+}
+
+type journalFile struct {
+	Delivered     []*Entry
+	History       []*Record
+	Alarms        []*Alarm
+	LastProcessed string `json:",omitempty"`
+}
+
+type Journal struct {
+	fname         string
+	mu            sync.Mutex
+	entries       map[string]*Entry
+	history       []*Record
+	alarms        map[string]*Alarm
+	lastProcessed string
+}
+
+func Open(fname string) (*Journal, error) {
+	if fname == "" {
+		return nil, fmt.Errorf("journal file name is empty")
+	}
+	jr := &Journal{fname: fname,
+		entries: make(map[string]*Entry),
+		history: make([]*Record, 0),
+		alarms:  make(map[string]*Alarm),
+	}
+	raw := json.RawMessage{}
+	found, err := jsonfile.Read(fname, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("journal %s is corrupted: %v", fname, err)
+	}
+	if !found {
+		log.Println("No delivery journal found, start an empty one", fname)
+		return jr, nil
+	}
+	content := journalFile{}
+	if bytes.HasPrefix(bytes.TrimSpace(raw), []byte("[")) {
+		// first version of the journal, only the delivered list
+		err = json.Unmarshal(raw, &content.Delivered)
+	} else {
+		err = json.Unmarshal(raw, &content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("journal %s is corrupted: %v", fname, err)
+	}
+	for _, e := range content.Delivered {
+		jr.entries[key(e.Item, e.Occurrence, e.Channel)] = e
+	}
+	if content.History != nil {
+		jr.history = content.History
+	}
+	for _, al := range content.Alarms {
+		jr.alarms[al.key()] = al
+	}
+	jr.lastProcessed = content.LastProcessed
+	log.Println("Delivery journal loaded, entries: ", len(jr.entries))
+	return jr, nil
+}
+
+func key(item, occurrence, channel string) string {
+	return fmt.Sprintf("%s|%s|%s", item, occurrence, channel)
+}
+
+func (jr *Journal) IsDelivered(item, occurrence, channel string) bool {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	_, ok := jr.entries[key(item, occurrence, channel)]
+	return ok
+}
+
+func (jr *Journal) MarkDelivered(item, occurrence, channel string) error {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	e := &Entry{Item: item, Occurrence: occurrence, Channel: channel, Time: time.Now()}
+	jr.entries[key(item, occurrence, channel)] = e
+	return jr.save()
+}
+
+func (jr *Journal) AddRecord(rec *Record) error {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	jr.history = append(jr.history, rec)
+	if len(jr.history) > historyRecords {
+		jr.history = jr.history[len(jr.history)-historyRecords:]
+	}
+	return jr.save()
+}
+
+// LastRecords returns the most recent delivery attempts of the channel, newest first
+func (jr *Journal) LastRecords(channel string, n int) []Record {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	res := make([]Record, 0, n)
+	for i := len(jr.history) - 1; i >= 0 && len(res) < n; i-- {
+		if jr.history[i].Channel == channel {
+			res = append(res, *jr.history[i])
+		}
+	}
+	return res
+}
+
+// LastProcessed returns the last day, as 2006-01-02, handled by the scheduler. Empty if none.
+func (jr *Journal) LastProcessed() string {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	return jr.lastProcessed
+}
+
+func (jr *Journal) SetLastProcessed(day string) error {
+	jr.mu.Lock()
+	defer jr.mu.Unlock()
+	if jr.lastProcessed == day {
+		return nil
+	}
+	jr.lastProcessed = day
+	return jr.save()
+}
+
+func (jr *Journal) save() error {
+	limit := time.Now().AddDate(0, 0, -keepDays)
+	content := journalFile{Delivered: make([]*Entry, 0, len(jr.entries)), History: jr.history, LastProcessed: jr.lastProcessed}
+	for k, e := range jr.entries {
+		if e.Time.Before(limit) {
+			delete(jr.entries, k)
+			continue
+		}
+		content.Delivered = append(content.Delivered, e)
+	}
+	limitAlarm := time.Now().AddDate(0, 0, -keepAlarmDays)
+	content.Alarms = make([]*Alarm, 0, len(jr.alarms))
+	for k, al := range jr.alarms {
+		if al.SentAt.Before(limitAlarm) {
+			delete(jr.alarms, k)
+			continue
+		}
+		content.Alarms = append(content.Alarms, al)
+	}
+	return jsonfile.WriteAtomic(jr.fname, &content)
+}
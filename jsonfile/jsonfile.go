@@ -0,0 +1,48 @@
+package jsonfile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Read decodes the json file into v. It returns false when the file does not exist.
+func Read(fname string, v interface{}) (bool, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WriteAtomic writes v into a temporary file that replaces fname only when complete,
+// so that a crash never leaves a truncated file behind.
+func WriteAtomic(fname string, v interface{}) error {
+	return WriteAtomicIndent(fname, v, "  ")
+}
+
+func WriteAtomicIndent(fname string, v interface{}, indent string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fname), filepath.Base(fname)+".tmp*")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(tmp)
+	enc.SetIndent("", indent)
+	if err := enc.Encode(v); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fname)
+}
@@ -2,74 +2,130 @@ package mail
 
 import (
 	"birthsch/conf"
-	"birthsch/idl"
 	"bytes"
+	"context"
 	"crypto/rand"
-	"crypto/tls"
 	"encoding/base64"
 	"fmt"
+	"html"
 	"html/template"
 	"io"
 	"log"
-	"net"
-	"net/smtp"
+	"strings"
 )
 
 type MailSender struct {
 	relay    conf.Relay
 	simulate bool
 	message  bytes.Buffer
+	ackURL   string
+	alarmID  string
+	rcpt     conf.Recipients
 }
 
 func (ms *MailSender) FillConf(simulate bool) {
-	ms.relay = *conf.Current.Relay
+	ms.relay = *conf.Current().Relay
 	ms.simulate = simulate
+	ms.rcpt = ms.relay.DefaultRecipients()
+	if conf.Current().Http != nil && conf.Current().Http.Enabled {
+		ms.ackURL = conf.Current().Http.PublicURL
+	}
 }
 
-func (ms *MailSender) BuildEmailMsgWithURL(templFileName string, URL string) error {
-	if !ms.relay.SendMail {
-		return nil
-	}
-	ms.message = bytes.Buffer{}
-	bound2 := randomBoundary()
+// SetTarget changes the recipient, used to escalate an alarm to somebody else
+func (ms *MailSender) SetTarget(target string) {
+	ms.rcpt = conf.Recipients{To: []string{target}}
+}
 
-	var partHTMLCont, partSubj, partPlainContent bytes.Buffer
-	tmplBodyMail := template.Must(template.New("MailBody").ParseFiles(templFileName))
-	if err := tmplBodyMail.ExecuteTemplate(&partHTMLCont, "mailbody", URL); err != nil {
-		return err
-	}
-	if err := tmplBodyMail.ExecuteTemplate(&partSubj, "mailSubj", URL); err != nil {
-		return err
-	}
+// WithRecipients returns a sender with the same relay for other recipients
+func (ms *MailSender) WithRecipients(rcpt conf.Recipients) *MailSender {
+	return &MailSender{relay: ms.relay, simulate: ms.simulate, ackURL: ms.ackURL, rcpt: rcpt}
+}
+
+// Recipients are the addresses the mails are sent to
+func (ms *MailSender) Recipients() conf.Recipients {
+	return ms.rcpt
+}
+
+func (ms *MailSender) Name() string {
+	return "mail"
+}
 
-	if err := tmplBodyMail.ExecuteTemplate(&partPlainContent, "mailPlain", URL); err != nil {
+func (ms *MailSender) Notify(ctx context.Context, templFileName string, data interface{}) error {
+	if err := ms.BuildEmailMsg(templFileName, data); err != nil {
 		return err
 	}
+	return ms.SendEmailViaRelay(ctx)
+}
 
-	return ms.writeMsg(partSubj, bound2, partPlainContent, partHTMLCont)
+// NotifyWithAck adds to the mail the link that acknowledges the alarm
+func (ms *MailSender) NotifyWithAck(ctx context.Context, templFileName string, data interface{}, alarmID string) error {
+	ms.alarmID = alarmID
+	defer func() { ms.alarmID = "" }()
+	return ms.Notify(ctx, templFileName, data)
+}
+
+// CanAck is true when the mail has the link to the ack page
+func (ms *MailSender) CanAck() bool {
+	return ms.ackURL != ""
 }
 
-func (ms *MailSender) BuildEmailMsg(templFileName string, listsrc []*idl.SchedNextItem) error {
+func (ms *MailSender) BuildEmailMsg(templFileName string, data interface{}) error {
 	if !ms.relay.SendMail {
 		return nil
 	}
 	ms.message = bytes.Buffer{}
 	bound2 := randomBoundary()
 
-	var partHTMLCont, partSubj, partPlainContent bytes.Buffer
-	tmplBodyMail := template.Must(template.New("MailBody").ParseFiles(templFileName))
-	if err := tmplBodyMail.ExecuteTemplate(&partHTMLCont, "mailbody", listsrc); err != nil {
-		return err
-	}
-	if err := tmplBodyMail.ExecuteTemplate(&partSubj, "mailSubj", listsrc); err != nil {
+	partSubj, partPlainContent, partHTMLCont, err := ms.renderParts(templFileName, data)
+	if err != nil {
 		return err
 	}
+	return ms.writeMsg(partSubj, bound2, partPlainContent, partHTMLCont)
+}
 
-	if err := tmplBodyMail.ExecuteTemplate(&partPlainContent, "mailPlain", listsrc); err != nil {
-		return err
-	}
+// Preview renders the mail without sending it, the html part is not encoded
+func (ms *MailSender) Preview(templFileName string, data interface{}) (string, error) {
+	partSubj, partPlainContent, partHTMLCont, err := ms.renderParts(templFileName, data)
+	if err != nil {
+		return "", err
+	}
+	res := bytes.Buffer{}
+	partSubj.WriteTo(&res)
+	fmt.Fprintf(&res, "To: %s\n", strings.Join(ms.rcpt.To, ", "))
+	if len(ms.rcpt.Cc) > 0 {
+		fmt.Fprintf(&res, "Cc: %s\n", strings.Join(ms.rcpt.Cc, ", "))
+	}
+	if len(ms.rcpt.Bcc) > 0 {
+		fmt.Fprintf(&res, "Bcc: %s\n", strings.Join(ms.rcpt.Bcc, ", "))
+	}
+	res.WriteString("\n")
+	partPlainContent.WriteTo(&res)
+	res.WriteString("\n\n")
+	partHTMLCont.WriteTo(&res)
+	return res.String(), nil
+}
 
-	return ms.writeMsg(partSubj, bound2, partPlainContent, partHTMLCont)
+func (ms *MailSender) renderParts(templFileName string, data interface{}) (partSubj, partPlainContent, partHTMLCont bytes.Buffer, err error) {
+	tmplBodyMail, err := template.New("MailBody").ParseFiles(templFileName)
+	if err != nil {
+		return
+	}
+	if err = tmplBodyMail.ExecuteTemplate(&partHTMLCont, "mailbody", data); err != nil {
+		return
+	}
+	if err = tmplBodyMail.ExecuteTemplate(&partSubj, "mailSubj", data); err != nil {
+		return
+	}
+	if err = tmplBodyMail.ExecuteTemplate(&partPlainContent, "mailPlain", data); err != nil {
+		return
+	}
+	if ms.alarmID != "" && ms.ackURL != "" {
+		link := strings.TrimSuffix(ms.ackURL, "/") + "/ack/" + ms.alarmID
+		fmt.Fprintf(&partPlainContent, "\r\n\r\nPlease confirm that you have seen this alarm: %s\r\n", link)
+		fmt.Fprintf(&partHTMLCont, `<p>Please <a href="%s">confirm</a> that you have seen this alarm.</p>`, html.EscapeString(link))
+	}
+	return
 }
 
 func (ms *MailSender) writeMsg(partSubj bytes.Buffer, bound2 string, partPlainContent bytes.Buffer, partHTMLCont bytes.Buffer) error {
@@ -79,7 +135,13 @@ func (ms *MailSender) writeMsg(partSubj bytes.Buffer, bound2 string, partPlainCo
 	if ms.relay.MailFrom != "" {
 		msg.Write([]byte("From: " + ms.relay.MailFrom + "\r\n"))
 	}
-	msg.Write([]byte("To: " + ms.relay.EmailTarget + "\r\n"))
+	// Bcc is only in the envelope
+	if len(ms.rcpt.To) > 0 {
+		msg.Write([]byte("To: " + strings.Join(ms.rcpt.To, ", ") + "\r\n"))
+	}
+	if len(ms.rcpt.Cc) > 0 {
+		msg.Write([]byte("Cc: " + strings.Join(ms.rcpt.Cc, ", ") + "\r\n"))
+	}
 	msg.Write([]byte("Content-Type:  multipart/alternative; boundary=" + `"` + bound2 + `"` + "\r\n"))
 	msg.Write([]byte("\r\n"))
 	// plain section
@@ -108,7 +170,8 @@ func (ms *MailSender) writeMsg(partSubj bytes.Buffer, bound2 string, partPlainCo
 	return nil
 }
 
-func (ms *MailSender) SendEmailViaRelay() error {
+// SendEmailViaRelay sends the message, the smtp transaction is aborted when ctx is canceled
+func (ms *MailSender) SendEmailViaRelay(ctx context.Context) error {
 	if !ms.relay.SendMail {
 		log.Println("sending mail is not configured")
 		return nil
@@ -124,60 +187,15 @@ func (ms *MailSender) SendEmailViaRelay() error {
 	if len_msg == 0 {
 		return fmt.Errorf("message mail is empty")
 	}
-
-	servername := ms.relay.Host
-
-	host, _, _ := net.SplitHostPort(servername)
-
-	auth := smtp.PlainAuth("", ms.relay.User, ms.relay.Secret, host)
-
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         host,
-	}
-
-	log.Println("Dial server ", servername)
-	conn, err := tls.Dial("tcp", servername, tlsconfig)
-	if err != nil {
-		return err
-	}
-
-	c, err := smtp.NewClient(conn, host)
-	if err != nil {
-		return err
-	}
-
-	log.Println("Send smtp Auth")
-	if err = c.Auth(auth); err != nil {
-		return err
-	}
-
-	log.Println("send From", ms.relay.MailFrom)
-	if err = c.Mail(ms.relay.MailFrom); err != nil {
-		return err
-	}
-	log.Println("send To", ms.relay.EmailTarget)
-	if err = c.Rcpt(ms.relay.EmailTarget); err != nil {
-		return err
+	recipients := ms.rcpt.All()
+	if len(recipients) == 0 {
+		return fmt.Errorf("mail has no recipients")
 	}
 
-	w, err := c.Data()
-	if err != nil {
-		return err
-	}
 	log.Println("Send the message to the relay (len)", len_msg, ms.message.String())
-	nbt, err := w.Write(ms.message.Bytes())
-	if err != nil {
-		return err
-	}
-	log.Println("Close relay after ", nbt)
-	err = w.Close()
-	if err != nil {
-		log.Println("[SendEmailViaRelay] error ", err)
+	if err := SendSMTP(ctx, &ms.relay, ms.relay.MailFrom, recipients, ms.message.Bytes()); err != nil {
 		return err
 	}
-	log.Println("Quit relay")
-	c.Quit()
 	log.Println("E-Mail is on the way. Everything is going well.")
 
 	return nil
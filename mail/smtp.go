@@ -0,0 +1,172 @@
+package mail
+
+import (
+	"birthsch/conf"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// SendSMTP delivers msg to the relay with one RCPT for each address of to.
+// The connection follows Security and Auth of the relay, the transaction is
+// aborted when ctx is canceled.
+func SendSMTP(ctx context.Context, relay *conf.Relay, from string, to []string, msg []byte) error {
+	host, _, err := net.SplitHostPort(relay.Host)
+	if err != nil {
+		return fmt.Errorf("relay Host %q: %v", relay.Host, err)
+	}
+	tlsconfig, err := tlsConfig(relay, host)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Dial server ", relay.Host, relay.Security)
+	rawConn, err := (&net.Dialer{}).DialContext(ctx, "tcp", relay.Host)
+	if err != nil {
+		return err
+	}
+	// net/smtp has no context, closing the connection aborts the transaction
+	stop := context.AfterFunc(ctx, func() { rawConn.Close() })
+	defer stop()
+
+	conn := rawConn
+	if relay.Security == conf.SecurityTLS {
+		tlsConn := tls.Client(rawConn, tlsconfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			rawConn.Close()
+			return err
+		}
+		conn = tlsConn
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		rawConn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if relay.Security == conf.SecurityStartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("relay %s does not support STARTTLS", relay.Host)
+		}
+		log.Println("Send smtp STARTTLS")
+		if err := c.StartTLS(tlsconfig); err != nil {
+			return err
+		}
+	}
+
+	if auth := smtpAuth(relay, host); auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("relay %s does not support AUTH", relay.Host)
+		}
+		log.Println("Send smtp Auth", relay.Auth)
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	log.Println("send From", from)
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		log.Println("send To", addr)
+		if err := c.Rcpt(addr); err != nil {
+			return fmt.Errorf("recipient %s: %w", addr, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	nbt, err := w.Write(msg)
+	if err != nil {
+		return err
+	}
+	log.Println("Close relay after ", nbt)
+	if err := w.Close(); err != nil {
+		log.Println("[SendSMTP] error ", err)
+		return err
+	}
+	log.Println("Quit relay")
+	c.Quit()
+	return nil
+}
+
+// tlsConfig verifies the relay certificate with the system roots and the CAFile
+func tlsConfig(relay *conf.Relay, host string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: relay.InsecureSkipVerify,
+	}
+	if relay.CAFile == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(relay.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in CAFile %s", relay.CAFile)
+	}
+	cfg.RootCAs = pool
+	return cfg, nil
+}
+
+func smtpAuth(relay *conf.Relay, host string) smtp.Auth {
+	switch relay.Auth {
+	case conf.AuthPlain:
+		return smtp.PlainAuth("", relay.User, relay.Secret, host)
+	case conf.AuthLogin:
+		return &loginAuth{username: relay.User, password: relay.Secret, host: host}
+	case conf.AuthCramMD5:
+		return smtp.CRAMMD5Auth(relay.User, relay.Secret)
+	}
+	return nil
+}
+
+// loginAuth is the LOGIN mechanism, not in net/smtp. Like PlainAuth it sends
+// the password only on TLS or to localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,311 @@
+package mail
+
+import (
+	"birthsch/conf"
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testUser   = "bot"
+	testSecret = "s3cret"
+)
+
+// fakeSMTP is a minimal relay on 127.0.0.1 that speaks tls, starttls or none
+// and checks the credentials of the PLAIN, LOGIN and CRAM-MD5 methods
+type fakeSMTP struct {
+	ln       net.Listener
+	security string
+	tlsCfg   *tls.Config
+	reject   map[string]bool
+
+	mu     sync.Mutex
+	rcpts  []string
+	data   string
+	authed string
+}
+
+func newFakeSMTP(t *testing.T, security string, cert tls.Certificate, reject ...string) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeSMTP{ln: ln, security: security,
+		tlsCfg: &tls.Config{Certificates: []tls.Certificate{cert}},
+		reject: map[string]bool{},
+	}
+	for _, addr := range reject {
+		srv.reject[addr] = true
+	}
+	if security == conf.SecurityTLS {
+		srv.ln = tls.NewListener(ln, srv.tlsCfg)
+	}
+	go srv.serve()
+	t.Cleanup(func() { srv.ln.Close() })
+	return srv
+}
+
+func (srv *fakeSMTP) addr() string {
+	return srv.ln.Addr().String()
+}
+
+func (srv *fakeSMTP) serve() {
+	for {
+		conn, err := srv.ln.Accept()
+		if err != nil {
+			return
+		}
+		go srv.session(conn)
+	}
+}
+
+func (srv *fakeSMTP) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	readLine := func() (string, bool) {
+		line, err := r.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err == nil
+	}
+	_, isTLS := conn.(*tls.Conn)
+	reply("220 localhost ESMTP fake")
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			reply("250-localhost")
+			if srv.security == conf.SecurityStartTLS && !isTLS {
+				reply("250-STARTTLS")
+			}
+			reply("250-AUTH PLAIN LOGIN CRAM-MD5")
+			reply("250 OK")
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, srv.tlsCfg)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, r, isTLS = tlsConn, bufio.NewReader(tlsConn), true
+		case "AUTH":
+			method, initial, _ := strings.Cut(arg, " ")
+			if srv.auth(strings.ToUpper(method), initial, reply, readLine) {
+				reply("235 authenticated")
+			} else {
+				reply("535 authentication failed")
+			}
+		case "MAIL":
+			reply("250 OK")
+		case "RCPT":
+			addr := strings.Trim(arg[strings.Index(arg, ":")+1:], "<>")
+			if srv.reject[addr] {
+				reply("550 no such user %s", addr)
+				continue
+			}
+			srv.mu.Lock()
+			srv.rcpts = append(srv.rcpts, addr)
+			srv.mu.Unlock()
+			reply("250 OK")
+		case "DATA":
+			reply("354 go ahead")
+			var sb strings.Builder
+			for {
+				l, ok := readLine()
+				if !ok {
+					return
+				}
+				if l == "." {
+					break
+				}
+				sb.WriteString(l + "\n")
+			}
+			srv.mu.Lock()
+			srv.data = sb.String()
+			srv.mu.Unlock()
+			reply("250 queued")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (srv *fakeSMTP) auth(method, initial string, reply func(string, ...interface{}), readLine func() (string, bool)) bool {
+	decode := func(s string) string {
+		b, _ := base64.StdEncoding.DecodeString(s)
+		return string(b)
+	}
+	ok := false
+	switch method {
+	case "PLAIN":
+		ok = decode(initial) == "\x00"+testUser+"\x00"+testSecret
+	case "LOGIN":
+		reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+		user, _ := readLine()
+		reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+		pass, _ := readLine()
+		ok = decode(user) == testUser && decode(pass) == testSecret
+	case "CRAM-MD5":
+		challenge := "<1234.5678@localhost>"
+		reply("334 %s", base64.StdEncoding.EncodeToString([]byte(challenge)))
+		resp, _ := readLine()
+		mac := hmac.New(md5.New, []byte(testSecret))
+		mac.Write([]byte(challenge))
+		ok = decode(resp) == testUser+" "+hex.EncodeToString(mac.Sum(nil))
+	}
+	if ok {
+		srv.mu.Lock()
+		srv.authed = method
+		srv.mu.Unlock()
+	}
+	return ok
+}
+
+// testCert creates a self signed certificate for 127.0.0.1 and writes it in
+// a CA file
+func testCert(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+func TestSendSMTP(t *testing.T) {
+	cert, caFile := testCert(t)
+	tests := []struct {
+		name     string
+		security string
+		auth     string
+		authed   string
+	}{
+		{"tls plain", conf.SecurityTLS, conf.AuthPlain, "PLAIN"},
+		{"tls login", conf.SecurityTLS, conf.AuthLogin, "LOGIN"},
+		{"starttls plain", conf.SecurityStartTLS, conf.AuthPlain, "PLAIN"},
+		{"starttls cram-md5", conf.SecurityStartTLS, conf.AuthCramMD5, "CRAM-MD5"},
+		{"none login", conf.SecurityNone, conf.AuthLogin, "LOGIN"},
+		{"none cram-md5", conf.SecurityNone, conf.AuthCramMD5, "CRAM-MD5"},
+		{"none without auth", conf.SecurityNone, conf.AuthNone, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFakeSMTP(t, tc.security, cert)
+			relay := &conf.Relay{Host: srv.addr(), User: testUser, Secret: testSecret,
+				Security: tc.security, Auth: tc.auth, CAFile: caFile}
+			to := []string{"me@x.it", "wife@x.it"}
+			msg := []byte("Subject: test\r\n\r\nhello\r\n")
+			if err := SendSMTP(context.Background(), relay, "bot@x.it", to, msg); err != nil {
+				t.Fatalf("SendSMTP: %v", err)
+			}
+			srv.mu.Lock()
+			defer srv.mu.Unlock()
+			if srv.authed != tc.authed {
+				t.Errorf("authenticated with %q, want %q", srv.authed, tc.authed)
+			}
+			if strings.Join(srv.rcpts, ",") != strings.Join(to, ",") {
+				t.Errorf("recipients %v, want %v", srv.rcpts, to)
+			}
+			if !strings.Contains(srv.data, "hello") {
+				t.Errorf("message not received: %q", srv.data)
+			}
+		})
+	}
+}
+
+func TestSendSMTPWrongSecret(t *testing.T) {
+	cert, _ := testCert(t)
+	srv := newFakeSMTP(t, conf.SecurityNone, cert)
+	relay := &conf.Relay{Host: srv.addr(), User: testUser, Secret: "wrong",
+		Security: conf.SecurityNone, Auth: conf.AuthLogin}
+	if err := SendSMTP(context.Background(), relay, "bot@x.it", []string{"me@x.it"}, []byte("hello")); err == nil {
+		t.Fatal("SendSMTP with a wrong secret succeeded")
+	}
+}
+
+func TestSendSMTPRejectedRcpt(t *testing.T) {
+	cert, _ := testCert(t)
+	srv := newFakeSMTP(t, conf.SecurityNone, cert, "nobody@x.it")
+	relay := &conf.Relay{Host: srv.addr(), Security: conf.SecurityNone, Auth: conf.AuthNone}
+	err := SendSMTP(context.Background(), relay, "bot@x.it", []string{"me@x.it", "nobody@x.it"}, []byte("hello"))
+	if err == nil {
+		t.Fatal("SendSMTP with a rejected recipient succeeded")
+	}
+	if !strings.Contains(err.Error(), "nobody@x.it") {
+		t.Errorf("error %q does not name the rejected recipient", err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.data != "" {
+		t.Errorf("message sent after a rejected recipient: %q", srv.data)
+	}
+}
+
+func TestSendSMTPUnverifiedCert(t *testing.T) {
+	cert, _ := testCert(t)
+	for _, security := range []string{conf.SecurityTLS, conf.SecurityStartTLS} {
+		t.Run(security, func(t *testing.T) {
+			srv := newFakeSMTP(t, security, cert)
+			relay := &conf.Relay{Host: srv.addr(), Security: security, Auth: conf.AuthNone}
+			err := SendSMTP(context.Background(), relay, "bot@x.it", []string{"me@x.it"}, []byte("hello"))
+			var verr *tls.CertificateVerificationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("error %v, want a certificate verification error", err)
+			}
+			relay.InsecureSkipVerify = true
+			if err := SendSMTP(context.Background(), relay, "bot@x.it", []string{"me@x.it"}, []byte("hello")); err != nil {
+				t.Fatalf("SendSMTP with InsecureSkipVerify: %v", err)
+			}
+		})
+	}
+}
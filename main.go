@@ -12,6 +12,7 @@ func main() {
 	var ver = flag.Bool("ver", false, "Prints the current version")
 	var configfile = flag.String("config", "config.toml", "Configuration file path")
 	var simulate = flag.Bool("simulate", false, "Simulate sending alarm")
+	flag.Usage = usage
 	flag.Parse()
 
 	if *ver {
@@ -19,6 +20,14 @@ func main() {
 		os.Exit(0)
 	}
 
+	if flag.NArg() > 0 {
+		if err := runCommand(*configfile, flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if err := sch.RunService(*configfile, *simulate); err != nil {
 		panic(err)
 	}
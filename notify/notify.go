@@ -0,0 +1,191 @@
+package notify
+
+import (
+	"birthsch/conf"
+	"birthsch/idl"
+	"birthsch/mail"
+	"birthsch/telegram"
+	"context"
+	"log"
+)
+
+// Notifier is a channel where an alarm can be delivered.
+// The data is passed as is to the alarm template.
+// The delivery is aborted when ctx is canceled.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, templFileName string, data interface{}) error
+}
+
+// AckNotifier is a Notifier that lets the user acknowledge or snooze the alarm.
+// CanAck is false when the configuration gives no way to do it.
+type AckNotifier interface {
+	Notifier
+	NotifyWithAck(ctx context.Context, templFileName string, data interface{}, alarmID string) error
+	CanAck() bool
+}
+
+// Previewer renders the alarm as it would be sent, without sending it
+type Previewer interface {
+	Notifier
+	Preview(templFileName string, data interface{}) (string, error)
+}
+
+type factory func(cfg *conf.Config, simulate, debug bool) (Notifier, bool)
+
+// To add a new channel, implement Notifier and append its factory here
+var factories = []factory{
+	func(cfg *conf.Config, simulate, debug bool) (Notifier, bool) {
+		if cfg.Relay == nil || !cfg.Relay.SendMail {
+			return nil, false
+		}
+		ms := &mail.MailSender{}
+		ms.FillConf(simulate)
+		return ms, true
+	},
+	func(cfg *conf.Config, simulate, debug bool) (Notifier, bool) {
+		if cfg.Telegram == nil || !cfg.Telegram.SendTelegram {
+			return nil, false
+		}
+		ts := &telegram.TelegramSender{}
+		ts.FillConf(simulate, debug)
+		return ts, true
+	},
+}
+
+type Registry struct {
+	notifiers []Notifier
+	cfg       *conf.Config
+	simulate  bool
+}
+
+func NewRegistry(cfg *conf.Config, simulate, debug bool) *Registry {
+	reg := &Registry{notifiers: make([]Notifier, 0), cfg: cfg, simulate: simulate}
+	for _, fn := range factories {
+		if nt, ok := fn(cfg, simulate, debug); ok {
+			log.Println("Notifier enabled: ", nt.Name())
+			reg.notifiers = append(reg.notifiers, nt)
+		}
+	}
+	if len(reg.notifiers) == 0 {
+		log.Println("No notifier is enabled, alarms are not delivered")
+	}
+	return reg
+}
+
+// NewRegistryOf returns a registry with the given notifiers, like the fake
+// ones of a test
+func NewRegistryOf(cfg *conf.Config, notifiers ...Notifier) *Registry {
+	return &Registry{notifiers: notifiers, cfg: cfg}
+}
+
+func (reg *Registry) Notifiers() []Notifier {
+	return reg.notifiers
+}
+
+func (reg *Registry) Names() []string {
+	res := make([]string, 0, len(reg.notifiers))
+	for _, nt := range reg.notifiers {
+		res = append(res, nt.Name())
+	}
+	return res
+}
+
+func (reg *Registry) Get(name string) Notifier {
+	for _, nt := range reg.notifiers {
+		if nt.Name() == name {
+			return nt
+		}
+	}
+	return nil
+}
+
+// MailTo returns a mail notifier for a recipient other than the configured one.
+// Nil when the mail is not enabled.
+func (reg *Registry) MailTo(target string) Notifier {
+	if reg.cfg.Relay == nil || !reg.cfg.Relay.SendMail {
+		return nil
+	}
+	ms := &mail.MailSender{}
+	ms.FillConf(reg.simulate)
+	ms.SetTarget(target)
+	return ms
+}
+
+// Route is a part of the alarm items with the notifier that delivers it.
+// Target names the chat of a telegram route or the recipients of a mail route,
+// it is empty for the default ones.
+type Route struct {
+	Notifier Notifier
+	Items    []*idl.SchedNextItem
+	Target   string
+}
+
+// Routes splits the items by the targets of the channel. The mails are grouped
+// by the recipients of each event, the telegram messages by chat. Without items,
+// like for a digest, the routes go to the default targets.
+func (reg *Registry) Routes(nt Notifier, items []*idl.SchedNextItem) []Route {
+	switch sender := nt.(type) {
+	case *mail.MailSender:
+		return reg.mailRoutes(sender, items)
+	case *telegram.TelegramSender:
+		return reg.telegramRoutes(sender, items)
+	}
+	return []Route{{Notifier: nt, Items: items}}
+}
+
+func (reg *Registry) mailRoutes(ms *mail.MailSender, items []*idl.SchedNextItem) []Route {
+	if len(items) == 0 {
+		return []Route{{Notifier: ms}}
+	}
+	res := make([]Route, 0, 1)
+	index := make(map[string]int)
+	for _, item := range items {
+		rcpt := reg.cfg.Relay.RecipientsFor(item.Group, item.Recipients)
+		key := rcpt.Key()
+		i, found := index[key]
+		if !found {
+			route := Route{Notifier: ms}
+			if key != ms.Recipients().Key() {
+				route = Route{Notifier: ms.WithRecipients(rcpt), Target: key}
+			}
+			i = len(res)
+			index[key] = i
+			res = append(res, route)
+		}
+		res[i].Items = append(res[i].Items, item)
+	}
+	return res
+}
+
+// telegramRoutes has a route for each chat, so that a chat that fails is
+// retried alone
+func (reg *Registry) telegramRoutes(ts *telegram.TelegramSender, items []*idl.SchedNextItem) []Route {
+	res := make([]Route, 0, 1)
+	if len(items) == 0 {
+		for _, chat := range ts.Chats() {
+			res = append(res, Route{Notifier: ts.ForChat(chat), Target: chat.Name})
+		}
+		if len(res) == 0 {
+			return []Route{{Notifier: ts}}
+		}
+		return res
+	}
+	index := make(map[string]int)
+	for _, item := range items {
+		for _, chat := range reg.cfg.Telegram.ChatsFor(item.Chats, item.Tags) {
+			i, found := index[chat.Name]
+			if !found {
+				i = len(res)
+				index[chat.Name] = i
+				res = append(res, Route{Notifier: ts.ForChat(chat), Target: chat.Name})
+			}
+			res[i].Items = append(res[i].Items, item)
+		}
+	}
+	if len(res) == 0 {
+		// no chat is configured, the delivery fails with its error
+		return []Route{{Notifier: ts, Items: items}}
+	}
+	return res
+}
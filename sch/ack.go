@@ -0,0 +1,82 @@
+package sch
+
+import (
+	"birthsch/journal"
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	snoozeEveningHour  = 18
+	snoozeTomorrowHour = 9
+)
+
+// processSnoozed sends again the alarms that are not acknowledged at the snooze time
+func (sch *Scheduler) processSnoozed(ctx context.Context, now time.Time) error {
+	alarms, err := sch.journal.TakeDueSnoozed(now)
+	if err != nil {
+		return err
+	}
+	for i := range alarms {
+		al := alarms[i]
+		nt := sch.alarmNotifier(&al)
+		if nt == nil {
+			log.Println("snoozed alarm on a channel not enabled anymore ", al.Channel)
+			continue
+		}
+		log.Println("Send snoozed alarm again ", al.ID, al.Channel)
+		pd := &pendingDelivery{notifier: nt, templ: al.Template, data: al.Items, alarm: &al}
+		if err := sch.tryDelivery(ctx, pd, now); err != nil {
+			return err
+		}
+		// the escalation starts again from the snoozed delivery
+		if err := sch.journal.UpdateAlarmCopy(&al, func(stored *journal.Alarm) {
+			stored.SentAt = now
+			stored.ResentAt = time.Time{}
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AckAlarm records that the alarm has been seen, it is not sent again
+func (sch *Scheduler) AckAlarm(id string) error {
+	now := sch.now()
+	al, err := sch.journal.AckAlarm(id, now)
+	if err != nil {
+		return err
+	}
+	log.Println("Alarm acknowledged ", id)
+	sch.wake()
+	return sch.journal.AddRecord(&journal.Record{
+		Time:        now,
+		Channel:     al.Channel,
+		Description: describeItems(al.Items, nil, al.Template),
+		Status:      journal.StatusAcked,
+	})
+}
+
+// SnoozeAlarm sends the alarm again in the evening or tomorrow morning
+func (sch *Scheduler) SnoozeAlarm(id string, tomorrow bool) (time.Time, error) {
+	now := sch.now()
+	until := time.Date(now.Year(), now.Month(), now.Day(), snoozeEveningHour, 0, 0, 0, now.Location())
+	if tomorrow {
+		until = time.Date(now.Year(), now.Month(), now.Day()+1, snoozeTomorrowHour, 0, 0, 0, now.Location())
+	} else if !now.Before(until) {
+		until = until.AddDate(0, 0, 1)
+	}
+	al, err := sch.journal.SnoozeAlarm(id, until)
+	if err != nil {
+		return until, err
+	}
+	log.Println("Alarm snoozed until ", id, until)
+	sch.wake()
+	return until, sch.journal.AddRecord(&journal.Record{
+		Time:        now,
+		Channel:     al.Channel,
+		Description: describeItems(al.Items, nil, al.Template),
+		Status:      journal.StatusSnoozed,
+	})
+}
@@ -0,0 +1,83 @@
+package sch
+
+import (
+	"birthsch/conf"
+	"birthsch/idl"
+	"context"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	dayFormat      = "2006-01-02"
+	maxCatchUpDays = 366
+)
+
+// catchUp looks at the days since the last processed one, when the service was
+// down, and sends a summary of the events that occurred without any alarm
+func (sch *Scheduler) catchUp(ctx context.Context, now time.Time) error {
+	last := sch.journal.LastProcessed()
+	if last == "" {
+		log.Println("No last processed day, nothing to catch up")
+		return nil
+	}
+	lastDay, err := time.ParseInLocation(dayFormat, last, now.Location())
+	if err != nil {
+		log.Println("Last processed day is not valid, nothing to catch up: ", err)
+		return nil
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if !lastDay.Before(today) {
+		return nil
+	}
+	if idl.DaysBetween(lastDay, today) > maxCatchUpDays {
+		lastDay = today.AddDate(0, 0, -maxCatchUpDays)
+	}
+	log.Printf("Catch up the days from %s to %s", lastDay.Format(dayFormat), today.Format(dayFormat))
+
+	schList, err := sch.store.Load()
+	if err != nil {
+		return err
+	}
+	missed := make([]*idl.SchedNextItem, 0)
+	for day := lastDay; day.Before(today); day = day.AddDate(0, 0, 1) {
+		for _, item := range schList.List {
+			event, err := item.NextItem(day)
+			if err != nil {
+				return err
+			}
+			if event.DaysLeft != 0 || sch.wasNotified(event, item.EffectiveLeadDays(conf.Current().LeadDays)) {
+				continue
+			}
+			log.Println("missed while offline ", event)
+			missed = append(missed, event)
+		}
+	}
+	if len(missed) == 0 {
+		return nil
+	}
+	sort.SliceStable(missed, func(i, j int) bool { return missed[i].Time.Before(missed[j].Time) })
+	return sch.sendItemsOnChannels(ctx, "templates/missed-mail.html", missed)
+}
+
+// wasNotified is true when any reminder of the event was delivered on some channel
+func (sch *Scheduler) wasNotified(event *idl.SchedNextItem, leads []int) bool {
+	for _, lead := range leads {
+		reminder := *event
+		reminder.DaysLeft = lead
+		for _, ch := range sch.itemChannels(&reminder) {
+			if sch.journal.IsDelivered(reminder.Key(), reminder.Occurrence(), ch) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (sch *Scheduler) setLastProcessed(now time.Time) error {
+	if sch.simulation {
+		return nil
+	}
+	return sch.journal.SetLastProcessed(now.Format(dayFormat))
+}
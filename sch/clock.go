@@ -0,0 +1,40 @@
+package sch
+
+import (
+	"time"
+)
+
+const (
+	// the scheduler wakes up at least so often to notice wall clock jumps
+	maxSleep      = 10 * time.Minute
+	jumpTolerance = time.Minute
+)
+
+// Clock is the time source of the scheduler, a test can inject a fake one
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// clockJumped is true when the wall clock moved differently from the monotonic one,
+// like after a suspend and resume or a manual time change. Times without a
+// monotonic reading (a fake clock) never jump.
+func clockJumped(before, after time.Time) bool {
+	return isJump(after.Round(0).Sub(before.Round(0)), after.Sub(before))
+}
+
+// isJump compares the time elapsed on the wall clock with the monotonic one
+func isJump(wall, monotonic time.Duration) bool {
+	diff := wall - monotonic
+	return diff > jumpTolerance || diff < -jumpTolerance
+}
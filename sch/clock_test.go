@@ -0,0 +1,51 @@
+package sch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsJump(t *testing.T) {
+	tests := []struct {
+		name      string
+		wall      time.Duration
+		monotonic time.Duration
+		want      bool
+	}{
+		{"same elapsed", 10 * time.Minute, 10 * time.Minute, false},
+		{"small drift", 10*time.Minute + 30*time.Second, 10 * time.Minute, false},
+		{"drift at tolerance", 10*time.Minute + jumpTolerance, 10 * time.Minute, false},
+		{"resume after suspend", 3 * time.Hour, 10 * time.Minute, true},
+		{"clock set forward", 2 * time.Hour, 0, true},
+		{"clock set back", -time.Hour, time.Minute, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isJump(tc.wall, tc.monotonic); got != tc.want {
+				t.Errorf("isJump(%v, %v) = %v, want %v", tc.wall, tc.monotonic, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClockJumped(t *testing.T) {
+	now := time.Now()
+	fake := time.Date(2026, time.March, 29, 1, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		before, after time.Time
+		want          bool
+	}{
+		{"real clock", now, now.Add(maxSleep), false},
+		{"real clock in another location", now, now.Add(time.Minute).In(time.FixedZone("X", 3600)), false},
+		{"fake clock never jumps", fake, fake.Add(48 * time.Hour), false},
+		{"monotonic reading stripped", now, now.Add(5 * time.Hour).Round(0), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := clockJumped(tc.before, tc.after); got != tc.want {
+				t.Errorf("clockJumped = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
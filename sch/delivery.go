@@ -0,0 +1,204 @@
+package sch
+
+import (
+	"birthsch/conf"
+	"birthsch/idl"
+	"birthsch/journal"
+	"birthsch/notify"
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+type pendingDelivery struct {
+	notifier notify.Notifier
+	// target of the route, a chat or the mail recipients
+	target   string
+	templ    string
+	data     interface{}
+	items    []*idl.SchedNextItem
+	alarm    *journal.Alarm
+	attempts int
+	nextTry  time.Time
+	lastErr  error
+	// journal key of a delivery without items, like a digest
+	markKey        string
+	markOccurrence string
+}
+
+func (pd *pendingDelivery) description() string {
+	if len(pd.items) == 0 && pd.alarm != nil {
+		return describeItems(pd.alarm.Items, pd.data, pd.templ)
+	}
+	return describeItems(pd.items, pd.data, pd.templ)
+}
+
+// channel is the channel of the delivered journal
+func (pd *pendingDelivery) channel() string {
+	return deliveryChannel(pd.notifier.Name(), pd.target)
+}
+
+// deliveryChannel adds the target of the route to the channel, so that each
+// chat and each group of mail recipients is tracked alone in the journal
+func deliveryChannel(channel, target string) string {
+	if target == "" {
+		return channel
+	}
+	return channel + "/" + target
+}
+
+func describeItems(items []*idl.SchedNextItem, data interface{}, templ string) string {
+	if len(items) == 0 {
+		if info, ok := data.(*idl.WebChange); ok {
+			return info.URL
+		}
+		if dg, ok := data.(*idl.Digest); ok {
+			return dg.Title
+		}
+		return templ
+	}
+	names := []string{}
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// deliver tries once to send the alarm on the notifier. On failure the delivery
+// is queued for a retry, so that the other channels are not affected.
+// deliver sends the route, with alarmID the delivery is a copy of the alarm
+// that can be acknowledged on any of its channels
+func (sch *Scheduler) deliver(ctx context.Context, route notify.Route, templ string, data interface{}, alarmID string) error {
+	nt := route.Notifier
+	pd := &pendingDelivery{notifier: nt, target: route.Target, templ: templ, data: data, items: route.Items}
+	if alarmID != "" && len(route.Items) > 0 {
+		pd.alarm = &journal.Alarm{ID: alarmID, Template: templ, Items: route.Items, Channel: nt.Name(), Target: route.Target}
+	}
+	return sch.tryDelivery(ctx, pd, sch.now())
+}
+
+func (pd *pendingDelivery) notify(ctx context.Context) error {
+	if an, ok := pd.notifier.(notify.AckNotifier); ok && pd.alarm != nil {
+		return an.NotifyWithAck(ctx, pd.templ, pd.data, pd.alarm.ID)
+	}
+	return pd.notifier.Notify(ctx, pd.templ, pd.data)
+}
+
+func (sch *Scheduler) tryDelivery(ctx context.Context, pd *pendingDelivery, now time.Time) error {
+	if until, quiet := conf.Current().QuietUntil(now); quiet {
+		log.Printf("[%s] quiet hours, delivery deferred to %v", pd.notifier.Name(), until)
+		pd.nextTry = until
+		sch.retryQueue = append(sch.retryQueue, pd)
+		return nil
+	}
+	if ctx.Err() != nil {
+		log.Printf("[%s] shutdown, delivery not started: %s", pd.notifier.Name(), pd.description())
+		return nil
+	}
+	pd.attempts += 1
+	dctx, cancel := withGrace(ctx)
+	err := pd.notify(dctx)
+	cancel()
+	if err == nil {
+		if pd.attempts > 1 {
+			log.Printf("[%s] delivery succeeded after %d attempts", pd.notifier.Name(), pd.attempts)
+		}
+		if err := sch.addRecord(pd, journal.StatusSent, now); err != nil {
+			return err
+		}
+		if pd.alarm != nil && pd.alarm.SentAt.IsZero() && !sch.simulation {
+			pd.alarm.SentAt = now
+			if err := sch.journal.AddAlarm(pd.alarm); err != nil {
+				return err
+			}
+		}
+		if pd.markKey != "" && !sch.simulation {
+			if err := sch.journal.MarkDelivered(pd.markKey, pd.markOccurrence, pd.channel()); err != nil {
+				return err
+			}
+		}
+		return sch.markDelivered(pd.items, pd.channel())
+	}
+	pd.lastErr = err
+	retryCfg := conf.Current().Retry
+	log.Printf("[%s] delivery attempt %d/%d failed: %v", pd.notifier.Name(), pd.attempts, retryCfg.MaxAttempts, err)
+	if pd.attempts >= retryCfg.MaxAttempts {
+		if err := sch.addRecord(pd, journal.StatusFailed, now); err != nil {
+			return err
+		}
+		sch.sendDeliveryFailedAlarm(ctx, pd)
+		return nil
+	}
+	if err := sch.addRecord(pd, journal.StatusRetry, now); err != nil {
+		return err
+	}
+	delay := time.Duration(retryCfg.BaseDelaySec) * time.Second << (pd.attempts - 1)
+	if maxDelay := time.Duration(retryCfg.MaxDelaySec) * time.Second; delay <= 0 || delay > maxDelay {
+		delay = maxDelay
+	}
+	pd.nextTry = now.Add(delay)
+	log.Println("Delivery queued for retry at ", pd.nextTry)
+	sch.retryQueue = append(sch.retryQueue, pd)
+	return nil
+}
+
+func (sch *Scheduler) addRecord(pd *pendingDelivery, status string, now time.Time) error {
+	if sch.simulation {
+		return nil
+	}
+	rec := journal.Record{
+		Time:        now,
+		Channel:     pd.notifier.Name(),
+		Description: pd.description(),
+		Status:      status,
+		Attempt:     pd.attempts,
+	}
+	if pd.lastErr != nil && status != journal.StatusSent {
+		rec.Error = pd.lastErr.Error()
+	}
+	return sch.journal.AddRecord(&rec)
+}
+
+func (sch *Scheduler) processRetries(ctx context.Context, now time.Time) error {
+	if len(sch.retryQueue) == 0 {
+		return nil
+	}
+	due := make([]*pendingDelivery, 0)
+	waiting := make([]*pendingDelivery, 0)
+	for _, pd := range sch.retryQueue {
+		if now.Before(pd.nextTry) {
+			waiting = append(waiting, pd)
+		} else {
+			due = append(due, pd)
+		}
+	}
+	sch.retryQueue = waiting
+	for _, pd := range due {
+		if err := sch.tryDelivery(ctx, pd, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sch *Scheduler) sendDeliveryFailedAlarm(ctx context.Context, pd *pendingDelivery) {
+	templ := "templates/deliveryfailed-mail.html"
+	info := idl.DeliveryFailed{
+		Channel:     pd.notifier.Name(),
+		Attempts:    pd.attempts,
+		Error:       pd.lastErr.Error(),
+		Description: pd.description(),
+	}
+	log.Println("Delivery failed definitely, send the alert on the other channels", info)
+	for _, nt := range sch.notifiers.Notifiers() {
+		if nt.Name() == pd.notifier.Name() {
+			continue
+		}
+		for _, route := range sch.notifiers.Routes(nt, nil) {
+			if err := route.Notifier.Notify(ctx, templ, &info); err != nil {
+				log.Printf("[%s] delivery failed alert not sent: %v", nt.Name(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package sch
+
+import (
+	"birthsch/conf"
+	"birthsch/idl"
+	"birthsch/notify"
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	digestWeekly  = "weekly"
+	digestMonthly = "monthly"
+)
+
+func (sch *Scheduler) planDigests(now time.Time) {
+	for _, kind := range []string{digestWeekly, digestMonthly} {
+		key := "digest:" + kind
+		at, ok := conf.Current().DigestAt(now, kind == digestMonthly)
+		if !ok {
+			sch.queue.cancel(key)
+			continue
+		}
+		log.Printf("%s digest planned at %v", kind, at)
+		sch.queue.schedule(&action{key: key, kind: actionDigest, at: at, digest: kind})
+	}
+}
+
+// buildDigest collects the events of the next week or month, grouped by day.
+// The digest is nil when there are no events.
+func buildDigest(kind string, schList *idl.SchedList, now time.Time) (string, *idl.Digest, error) {
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	to := from.AddDate(0, 0, 7)
+	digest := idl.Digest{Title: "Weekly digest"}
+	templ := "templates/weekly-digest-mail.html"
+	if kind == digestMonthly {
+		to = from.AddDate(0, 1, 0)
+		digest.Title = "Monthly digest"
+		templ = "templates/monthly-digest-mail.html"
+	}
+	items, err := schList.Upcoming(from, idl.DaysBetween(from, to))
+	if err != nil || len(items) == 0 {
+		return templ, nil, err
+	}
+	digest.From = from
+	digest.To = to.AddDate(0, 0, -1)
+	digest.Count = len(items)
+	digest.Days = idl.GroupByDay(items)
+	return templ, &digest, nil
+}
+
+// sendDigest sends the weekly or monthly digest on the digest channels
+func (sch *Scheduler) sendDigest(ctx context.Context, kind string, now time.Time) error {
+	schList, err := sch.store.Load()
+	if err != nil {
+		return err
+	}
+	templ, digest, err := buildDigest(kind, schList, now)
+	if err != nil {
+		return err
+	}
+	if digest == nil {
+		log.Printf("No events for the %s digest", kind)
+		return nil
+	}
+
+	key := "digest/" + kind
+	occurrence := now.Format(dayFormat)
+	for _, nt := range selectNotifiers(sch.notifiers, conf.Current().Digest.Channels) {
+		for _, route := range sch.notifiers.Routes(nt, nil) {
+			channel := deliveryChannel(nt.Name(), route.Target)
+			if sch.journal.IsDelivered(key, occurrence, channel) {
+				log.Printf("%s digest already delivered on %s", kind, channel)
+				continue
+			}
+			pd := &pendingDelivery{notifier: route.Notifier, target: route.Target, templ: templ, data: digest, markKey: key, markOccurrence: occurrence}
+			if err := sch.tryDelivery(ctx, pd, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// selectNotifiers returns the notifiers with the given names, all when names is empty
+func selectNotifiers(reg *notify.Registry, names []string) []notify.Notifier {
+	if len(names) == 0 {
+		return reg.Notifiers()
+	}
+	res := make([]notify.Notifier, 0, len(names))
+	for _, name := range names {
+		if nt := reg.Get(name); nt != nil {
+			res = append(res, nt)
+		} else {
+			log.Printf("channel %s is not enabled", name)
+		}
+	}
+	return res
+}
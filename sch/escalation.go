@@ -0,0 +1,185 @@
+package sch
+
+import (
+	"birthsch/conf"
+	"birthsch/idl"
+	"birthsch/journal"
+	"birthsch/notify"
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// escalationFor returns the policy of the first item that has one
+func escalationFor(items []*idl.SchedNextItem) *conf.Escalation {
+	for _, item := range items {
+		if esc := conf.Current().EscalationFor(item.Escalation, item.EventType.String()); esc != nil {
+			return esc
+		}
+	}
+	return nil
+}
+
+// escalationSteps returns when the alarm has to be sent again and when it has
+// to be escalated, a zero time is for a step that is not needed anymore
+func escalationSteps(al *journal.Alarm, esc *conf.Escalation) (resendAt, escalateAt time.Time) {
+	if al.SentAt.IsZero() {
+		return
+	}
+	if esc.ResendAfterHours > 0 && al.ResentAt.IsZero() && al.EscalatedAt.IsZero() {
+		resendAt = al.SentAt.Add(time.Duration(esc.ResendAfterHours) * time.Hour)
+	}
+	if esc.EscalateAfterHours > 0 && al.EscalatedAt.IsZero() {
+		escalateAt = al.SentAt.Add(time.Duration(esc.EscalateAfterHours) * time.Hour)
+	}
+	return
+}
+
+// alarmGroup are the copies of an alarm on its channels and targets
+type alarmGroup struct {
+	copies []journal.Alarm
+	esc    *conf.Escalation
+}
+
+// escalationGroups returns the pending alarms with an escalation policy, grouped
+// by ID. An alarm that cannot be acknowledged on any of its channels is left out,
+// escalating it would be a punishment nobody can avoid.
+func (sch *Scheduler) escalationGroups() []*alarmGroup {
+	groups := make(map[string]*alarmGroup)
+	ids := make([]string, 0)
+	for _, al := range sch.journal.PendingAlarms() {
+		grp, ok := groups[al.ID]
+		if !ok {
+			grp = &alarmGroup{esc: escalationFor(al.Items)}
+			groups[al.ID] = grp
+			ids = append(ids, al.ID)
+		}
+		grp.copies = append(grp.copies, al)
+	}
+	sort.Strings(ids)
+	res := make([]*alarmGroup, 0, len(ids))
+	for _, id := range ids {
+		grp := groups[id]
+		if grp.esc == nil {
+			continue
+		}
+		if !sch.canAck(grp.copies) {
+			continue
+		}
+		res = append(res, grp)
+	}
+	return res
+}
+
+// canAck is true when one of the copies went on a channel where it can be acknowledged
+func (sch *Scheduler) canAck(copies []journal.Alarm) bool {
+	for _, al := range copies {
+		if an, ok := sch.notifiers.Get(al.Channel).(notify.AckNotifier); ok && an.CanAck() {
+			return true
+		}
+	}
+	return false
+}
+
+// steps returns the resend time of each copy and the escalation time of the
+// group, the earliest of its copies
+func (grp *alarmGroup) steps() (resendAt []time.Time, escalateAt time.Time) {
+	resendAt = make([]time.Time, len(grp.copies))
+	for i := range grp.copies {
+		var esc time.Time
+		resendAt[i], esc = escalationSteps(&grp.copies[i], grp.esc)
+		if !esc.IsZero() && (escalateAt.IsZero() || esc.Before(escalateAt)) {
+			escalateAt = esc
+		}
+	}
+	return
+}
+
+// processEscalations sends again the alarms that are not acknowledged in time
+// on the same channel and later on the escalation channels and recipients
+func (sch *Scheduler) processEscalations(ctx context.Context, now time.Time) error {
+	for _, grp := range sch.escalationGroups() {
+		resendAt, escalateAt := grp.steps()
+		for i, at := range resendAt {
+			if !at.IsZero() && !now.Before(at) {
+				if err := sch.resendAlarm(ctx, grp.copies[i], now); err != nil {
+					return err
+				}
+			}
+		}
+		if !escalateAt.IsZero() && !now.Before(escalateAt) {
+			if err := sch.escalateAlarm(ctx, grp.copies[0], grp.esc, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// nextEscalation returns the time of the next resend or escalation
+func (sch *Scheduler) nextEscalation() (time.Time, bool) {
+	var next time.Time
+	for _, grp := range sch.escalationGroups() {
+		resendAt, escalateAt := grp.steps()
+		for _, t := range append(resendAt, escalateAt) {
+			if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func (sch *Scheduler) resendAlarm(ctx context.Context, al journal.Alarm, now time.Time) error {
+	if err := sch.journal.UpdateAlarmCopy(&al, func(stored *journal.Alarm) { stored.ResentAt = now }); err != nil {
+		return err
+	}
+	nt := sch.alarmNotifier(&al)
+	if nt == nil {
+		log.Println("unacknowledged alarm on a channel not enabled anymore ", al.Channel)
+		return nil
+	}
+	log.Println("Alarm not acknowledged, send it again ", al.ID, al.Channel)
+	pd := &pendingDelivery{notifier: nt, templ: al.Template, data: al.Items, alarm: &al}
+	return sch.tryDelivery(ctx, pd, now)
+}
+
+func (sch *Scheduler) escalateAlarm(ctx context.Context, al journal.Alarm, esc *conf.Escalation, now time.Time) error {
+	if err := sch.journal.UpdateAlarm(al.ID, func(stored *journal.Alarm) { stored.EscalatedAt = now }); err != nil {
+		return err
+	}
+	targets := make([]notify.Notifier, 0)
+	for _, name := range esc.EscalateChannels {
+		if nt := sch.notifiers.Get(name); nt != nil {
+			targets = append(targets, nt)
+		} else {
+			log.Printf("escalation %s: channel %s is not enabled", esc.Name, name)
+		}
+	}
+	for _, email := range esc.EscalateEmails {
+		if nt := sch.notifiers.MailTo(email); nt != nil {
+			targets = append(targets, nt)
+		} else {
+			log.Printf("escalation %s: mail is not enabled, %s is not notified", esc.Name, email)
+		}
+	}
+	recipients := append(append([]string{}, esc.EscalateChannels...), esc.EscalateEmails...)
+	log.Printf("Alarm %s not acknowledged, escalate with policy %s to %d targets", al.ID, esc.Name, len(targets))
+	if err := sch.journal.AddRecord(&journal.Record{
+		Time:        now,
+		Channel:     al.Channel,
+		Description: describeItems(al.Items, nil, al.Template) + " -> " + strings.Join(recipients, ", "),
+		Status:      journal.StatusEscalated,
+	}); err != nil {
+		return err
+	}
+	for _, nt := range targets {
+		pd := &pendingDelivery{notifier: nt, templ: al.Template, data: al.Items, alarm: &al}
+		if err := sch.tryDelivery(ctx, pd, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
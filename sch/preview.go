@@ -0,0 +1,97 @@
+package sch
+
+import (
+	"birthsch/conf"
+	"birthsch/datafile"
+	"birthsch/idl"
+	"birthsch/notify"
+	"fmt"
+	"io"
+	"time"
+)
+
+type preview struct {
+	templ     string
+	data      interface{}
+	at        time.Time
+	notifiers []notify.Notifier
+}
+
+// Preview writes what would be sent on each channel on the day of day, nothing is sent
+func Preview(w io.Writer, day time.Time) error {
+	reg := notify.NewRegistry(conf.Current(), true, false)
+	if len(reg.Notifiers()) == 0 {
+		return fmt.Errorf("no channel is enabled")
+	}
+	schList, err := datafile.Read(conf.Current().DataFileName)
+	if err != nil {
+		return err
+	}
+	reminders, err := schList.Reminders(day, conf.Current().LeadDays)
+	if err != nil {
+		return err
+	}
+	previews := make([]*preview, 0)
+	templates := map[idl.EventType]string{
+		idl.Birthday:    "templates/birthday-mail.html",
+		idl.Anniversary: "templates/anniversary-mail.html",
+	}
+	for _, eventType := range []idl.EventType{idl.Birthday, idl.Anniversary} {
+		items := make([]*idl.SchedNextItem, 0)
+		for _, item := range reminders {
+			if item.EventType == eventType {
+				items = append(items, item)
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+		hh, mm := conf.Current().AlarmClock(eventType.String())
+		at := time.Date(day.Year(), day.Month(), day.Day(), hh, mm, 0, 0, day.Location())
+		previews = append(previews, &preview{templ: templates[eventType], data: items, at: at, notifiers: reg.Notifiers()})
+	}
+	for _, kind := range []string{digestWeekly, digestMonthly} {
+		at, ok := conf.Current().DigestAt(day, kind == digestMonthly)
+		if !ok {
+			continue
+		}
+		templ, digest, err := buildDigest(kind, schList, day)
+		if err != nil {
+			return err
+		}
+		if digest != nil {
+			previews = append(previews, &preview{templ: templ, data: digest, at: at, notifiers: selectNotifiers(reg, conf.Current().Digest.Channels)})
+		}
+	}
+
+	if len(previews) == 0 {
+		fmt.Fprintln(w, "Nothing to send on", day.Format(dayFormat))
+		return nil
+	}
+	for _, pv := range previews {
+		for _, nt := range pv.notifiers {
+			routes := []notify.Route{{Notifier: nt}}
+			if items, ok := pv.data.([]*idl.SchedNextItem); ok {
+				routes = reg.Routes(nt, items)
+			}
+			for _, route := range routes {
+				fmt.Fprintf(w, "=== %s at %s, %s ===\n", nt.Name(), pv.at.Format("2006-01-02 15:04"), pv.templ)
+				pnt, ok := route.Notifier.(notify.Previewer)
+				if !ok {
+					fmt.Fprintln(w, "channel without preview")
+					continue
+				}
+				data := pv.data
+				if route.Items != nil {
+					data = route.Items
+				}
+				text, err := pnt.Preview(pv.templ, data)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%s\n\n", text)
+			}
+		}
+	}
+	return nil
+}
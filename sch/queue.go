@@ -0,0 +1,113 @@
+package sch
+
+import (
+	"birthsch/watch"
+	"container/heap"
+	"time"
+)
+
+type actionKind int
+
+const (
+	actionDayRollover actionKind = iota
+	actionItemsAlarm
+	actionWatchCheck
+	actionRetry
+	actionSnoozed
+	actionEscalation
+	actionDigest
+)
+
+// action is something the scheduler has to do at a given time
+type action struct {
+	key       string
+	kind      actionKind
+	at        time.Time
+	eventType string
+	digest    string
+	watch     *watch.Watcher
+	index     int
+	// seq keeps the order of scheduling among the actions due at the same time
+	seq uint64
+}
+
+// actionQueue is a priority queue of actions ordered by due time.
+// The key of an action is unique, scheduling it again moves the action.
+type actionQueue struct {
+	items   []*action
+	byKey   map[string]*action
+	nextSeq uint64
+}
+
+func newActionQueue() *actionQueue {
+	return &actionQueue{items: make([]*action, 0), byKey: map[string]*action{}}
+}
+
+func (q *actionQueue) Len() int { return len(q.items) }
+
+func (q *actionQueue) Less(i, j int) bool {
+	if !q.items[i].at.Equal(q.items[j].at) {
+		return q.items[i].at.Before(q.items[j].at)
+	}
+	return q.items[i].seq < q.items[j].seq
+}
+
+func (q *actionQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *actionQueue) Push(x interface{}) {
+	act := x.(*action)
+	act.index = len(q.items)
+	q.items = append(q.items, act)
+	q.byKey[act.key] = act
+}
+
+func (q *actionQueue) Pop() interface{} {
+	n := len(q.items)
+	act := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	delete(q.byKey, act.key)
+	act.index = -1
+	return act
+}
+
+func (q *actionQueue) schedule(act *action) {
+	if old, ok := q.byKey[act.key]; ok {
+		old.at = act.at
+		old.kind = act.kind
+		old.eventType = act.eventType
+		old.digest = act.digest
+		old.watch = act.watch
+		heap.Fix(q, old.index)
+		return
+	}
+	q.nextSeq++
+	act.seq = q.nextSeq
+	heap.Push(q, act)
+}
+
+func (q *actionQueue) cancel(key string) {
+	if old, ok := q.byKey[key]; ok {
+		heap.Remove(q, old.index)
+	}
+}
+
+// next returns the first action to do, nil when the queue is empty
+func (q *actionQueue) next() *action {
+	if len(q.items) == 0 {
+		return nil
+	}
+	return q.items[0]
+}
+
+// popDue removes and returns the first action that is due at now, nil when none is due
+func (q *actionQueue) popDue(now time.Time) *action {
+	if act := q.next(); act != nil && !now.Before(act.at) {
+		return heap.Pop(q).(*action)
+	}
+	return nil
+}
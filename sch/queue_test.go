@@ -0,0 +1,91 @@
+package sch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActionQueue(t *testing.T) {
+	base := time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC)
+	at := func(min int) time.Time { return base.Add(time.Duration(min) * time.Minute) }
+	type op struct {
+		cancel bool
+		key    string
+		min    int
+	}
+	tests := []struct {
+		name string
+		ops  []op
+		// keys popped when all the actions are due, in order
+		want []string
+	}{
+		{"empty", nil, nil},
+		{"ordered by time", []op{{key: "c", min: 30}, {key: "a", min: 10}, {key: "b", min: 20}}, []string{"a", "b", "c"}},
+		{"reschedule moves later", []op{{key: "a", min: 10}, {key: "b", min: 20}, {key: "a", min: 30}}, []string{"b", "a"}},
+		{"reschedule moves earlier", []op{{key: "a", min: 10}, {key: "b", min: 20}, {key: "b", min: 5}}, []string{"b", "a"}},
+		{"same time in scheduling order", []op{{key: "c", min: 10}, {key: "a", min: 10}, {key: "d", min: 5}, {key: "b", min: 10}}, []string{"d", "c", "a", "b"}},
+		{"cancel by key", []op{{key: "a", min: 10}, {key: "b", min: 20}, {key: "c", min: 30}, {cancel: true, key: "b"}}, []string{"a", "c"}},
+		{"cancel unknown key", []op{{key: "a", min: 10}, {cancel: true, key: "x"}}, []string{"a"}},
+		{"schedule after cancel", []op{{key: "a", min: 10}, {cancel: true, key: "a"}, {key: "a", min: 40}, {key: "b", min: 20}}, []string{"b", "a"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			q := newActionQueue()
+			for _, o := range tc.ops {
+				if o.cancel {
+					q.cancel(o.key)
+				} else {
+					q.schedule(&action{key: o.key, at: at(o.min)})
+				}
+			}
+			if q.Len() != len(tc.want) {
+				t.Fatalf("queue has %d actions, want %d", q.Len(), len(tc.want))
+			}
+			got := []string{}
+			for act := q.popDue(at(60)); act != nil; act = q.popDue(at(60)) {
+				got = append(got, act.key)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("popped %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("popped %v, want %v", got, tc.want)
+				}
+			}
+			if q.next() != nil || len(q.byKey) != 0 {
+				t.Errorf("queue not empty after popping all the actions")
+			}
+		})
+	}
+}
+
+func TestActionQueuePopDue(t *testing.T) {
+	base := time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC)
+	q := newActionQueue()
+	q.schedule(&action{key: "rollover", kind: actionDayRollover, at: base.Add(time.Hour)})
+	q.schedule(&action{key: "alarm:Compl", kind: actionItemsAlarm, at: base})
+
+	if act := q.popDue(base.Add(-time.Second)); act != nil {
+		t.Fatalf("popDue before the due time returned %s", act.key)
+	}
+	if act := q.next(); act == nil || act.key != "alarm:Compl" {
+		t.Fatalf("next is %v, want alarm:Compl", act)
+	}
+	if act := q.popDue(base); act == nil || act.key != "alarm:Compl" {
+		t.Fatalf("popDue at the due time returned %v, want alarm:Compl", act)
+	}
+	if act := q.popDue(base); act != nil {
+		t.Fatalf("popDue returned %s that is not due", act.key)
+	}
+
+	// scheduling the same key replaces the action
+	q.schedule(&action{key: "rollover", kind: actionItemsAlarm, at: base, eventType: "Anniv"})
+	act := q.popDue(base)
+	if act == nil || act.kind != actionItemsAlarm || act.eventType != "Anniv" {
+		t.Fatalf("rescheduled action is %+v", act)
+	}
+	if q.Len() != 0 {
+		t.Errorf("queue has %d actions after the reschedule, want 0", q.Len())
+	}
+}
@@ -0,0 +1,95 @@
+package sch
+
+import (
+	"birthsch/conf"
+	"birthsch/notify"
+	"birthsch/watch"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const reloadPollInterval = 30 * time.Second
+
+// Reload asks the scheduler loop to read again the config and the data file
+func (sch *Scheduler) Reload() {
+	select {
+	case sch.chReload <- struct{}{}:
+	default:
+	}
+}
+
+// pollFiles asks a reload when the config or the data file are changed
+func (sch *Scheduler) pollFiles(chStop <-chan struct{}) {
+	files := append(conf.Files(sch.configfile), sch.store.FileName())
+	last := fileStamps(files)
+	for {
+		select {
+		case <-chStop:
+			return
+		case <-sch.clock.After(reloadPollInterval):
+		}
+		if current := fileStamps(files); current != last {
+			log.Println("config or data file changed, reload")
+			last = current
+			sch.Reload()
+		}
+	}
+}
+
+func fileStamps(files []string) string {
+	var sb strings.Builder
+	for _, fname := range files {
+		if fi, err := os.Stat(fname); err == nil {
+			fmt.Fprintf(&sb, "%s|%d|%d;", fname, fi.ModTime().UnixNano(), fi.Size())
+		} else {
+			fmt.Fprintf(&sb, "%s|-;", fname)
+		}
+	}
+	return sb.String()
+}
+
+// reloadFiles swaps in the new config and data file when they are valid,
+// otherwise the old ones are kept
+func (sch *Scheduler) reloadFiles() {
+	if err := sch.reloadConfig(); err != nil {
+		log.Println("Config not reloaded, the old one is kept: ", err)
+	}
+	if err := sch.store.Reload(); err != nil {
+		log.Println("Data file not reloaded, the old one is kept: ", err)
+	}
+}
+
+func (sch *Scheduler) reloadConfig() error {
+	cfg, err := conf.Load(sch.configfile)
+	if err != nil {
+		return err
+	}
+	for _, wcfg := range cfg.Watch {
+		if _, err := watch.New(wcfg, nil); err != nil {
+			return err
+		}
+	}
+	old := conf.Current()
+	if *cfg.Http != *old.Http || cfg.DataFileName != old.DataFileName ||
+		cfg.JournalFileName != old.JournalFileName || cfg.WatchStateFile != old.WatchStateFile ||
+		cfg.Telegram.EnableBot != old.Telegram.EnableBot {
+		log.Println("Changes to Http, the Telegram bot and the file names need a restart of the service")
+	}
+	conf.SetCurrent(cfg)
+	notifiers := notify.NewRegistry(cfg, sch.simulation, sch.debug)
+	sch.mu.Lock()
+	sch.notifiers = notifiers
+	sch.mu.Unlock()
+	for _, w := range sch.watchers {
+		sch.queue.cancel(watchKey(w))
+	}
+	if err := sch.createWatchers(); err != nil {
+		return err
+	}
+	sch.planWatches()
+	log.Println("Config reloaded from ", sch.configfile)
+	return nil
+}
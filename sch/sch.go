@@ -2,28 +2,43 @@ package sch
 
 import (
 	"birthsch/conf"
+	"birthsch/datafile"
 	"birthsch/idl"
-	"birthsch/mail"
+	"birthsch/journal"
+	"birthsch/notify"
 	"birthsch/telegram"
-	"encoding/json"
+	"birthsch/watch"
+	"birthsch/web"
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"strconv"
-	"strings"
+	"sync"
+	"syscall"
 	"time"
-
-	"github.com/gocolly/colly/v2"
 )
 
 type Scheduler struct {
-	datafileName    string
+	store           *datafile.Store
+	chReschedule    chan struct{}
+	journal         *journal.Journal
+	notifiers       *notify.Registry
+	chWake          chan struct{}
+	clock           Clock
+	queue           *actionQueue
+	retryQueue      []*pendingDelivery
 	nextBirthday    []*idl.SchedNextItem
 	nextAnniversary []*idl.SchedNextItem
-	monitoredURL    string
+	watchers        []*watch.Watcher
+	watchStore      *watch.StateStore
+	configfile      string
+	chReload        chan struct{}
 	simulation      bool
 	debug           bool
+	// mu guards notifiers and watchers: the scheduler loop replaces them on
+	// reload, the web server and the bot read them
+	mu sync.RWMutex
 }
 
 func RunService(configfile string, simulate bool) error {
@@ -32,29 +47,82 @@ func RunService(configfile string, simulate bool) error {
 		return err
 	}
 
-	chShutdown := make(chan struct{}, 1)
-	go func(chs chan struct{}) {
-		sch := Scheduler{datafileName: conf.Current.DataFileName,
-			simulation: (conf.Current.SimulateAlarm || simulate),
-			debug:      conf.Current.Debug,
-		}
-		if err := sch.doSchedule(); err != nil {
-			log.Println("Server is not scheduling anymore: ", err)
-			chs <- struct{}{}
+	jr, err := journal.Open(conf.Current().JournalFileName)
+	if err != nil {
+		return err
+	}
+
+	sch := &Scheduler{store: datafile.NewStore(conf.Current().DataFileName),
+		chReschedule: make(chan struct{}, 1),
+		chWake:       make(chan struct{}, 1),
+		chReload:     make(chan struct{}, 1),
+		configfile:   configfile,
+		clock:        realClock{},
+		queue:        newActionQueue(),
+		journal:      jr,
+		simulation:   (conf.Current().SimulateAlarm || simulate),
+		debug:        conf.Current().Debug,
+	}
+	sch.notifiers = notify.NewRegistry(conf.Current(), sch.simulation, sch.debug)
+	if err := sch.createWatchers(); err != nil {
+		return err
+	}
+
+	if conf.Current().Http.Enabled {
+		ws := web.NewServer(conf.Current().Http, sch.store, sch)
+		if err := ws.Start(); err != nil {
+			return err
 		}
-	}(chShutdown)
+		defer ws.Close()
+	}
+
+	if conf.Current().Telegram.EnableBot {
+		bot := telegram.NewBot(conf.Current().Telegram, sch.debug, sch.store, sch)
+		chStopBot := make(chan struct{})
+		defer close(chStopBot)
+		go func() {
+			if err := bot.Run(chStopBot); err != nil {
+				log.Println("Telegram bot is not running: ", err)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chDone := make(chan error, 1)
+	go func() {
+		chDone <- sch.doSchedule(ctx)
+	}()
 
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	chStopPoll := make(chan struct{})
+	defer close(chStopPoll)
+	go sch.pollFiles(chStopPoll)
 	log.Println("Enter in server blocking loop")
 
 loop:
 	for {
 		select {
-		case <-sig:
-			log.Println("stop because interrupt")
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				log.Println("reload requested by SIGHUP")
+				sch.Reload()
+				continue
+			}
+			log.Println("stop because of signal ", s)
+			cancel()
+			select {
+			case err := <-chDone:
+				if err != nil {
+					log.Println("Scheduler stopped with error: ", err)
+				}
+			case <-time.After(shutdownTimeout):
+				log.Println("Scheduler did not stop in time, exit anyway")
+			}
 			break loop
-		case <-chShutdown:
+		case err := <-chDone:
+			log.Println("Server is not scheduling anymore: ", err)
 			log.Println("stop because service shutdown on scheduling")
 			log.Fatal("Force with an error to restart the service")
 		}
@@ -64,150 +132,353 @@ loop:
 	return nil
 }
 
-func (sch *Scheduler) checkSite() error {
-	URL := sch.monitoredURL
-	if URL == "" {
+// createWatchers builds the watchers of the config, the ones with an unchanged
+// configuration are kept so that they keep their next check time
+func (sch *Scheduler) createWatchers() error {
+	old := sch.watchers
+	watchers := make([]*watch.Watcher, 0)
+	defer func() {
+		sch.mu.Lock()
+		sch.watchers = watchers
+		sch.mu.Unlock()
+	}()
+	if len(conf.Current().Watch) == 0 {
 		return nil
 	}
-	log.Println("Check URL for", URL)
-	c := colly.NewCollector()
-	c.OnHTML("body > main > section.event-hero.bg-mono-darkest.color-brand-primary > div.event-hero__content > div > div > div:nth-child(1) > div > div.event-hero__buttons.mt-5 > p", func(e *colly.HTMLElement) {
-		pp := e.Text
-		log.Println("Site checked: ", e)
-		if !strings.Contains(pp, "Check back soon for entry details on this race") {
-			log.Println("Site has changed to: ", pp)
-			if err := sch.sendWebChangedAlarm(URL); err != nil {
-				log.Println("[OnHTML] error ", err)
+	if sch.watchStore == nil {
+		store, err := watch.OpenStateStore(conf.Current().WatchStateFile)
+		if err != nil {
+			return err
+		}
+		sch.watchStore = store
+	}
+	for _, wcfg := range conf.Current().Watch {
+		w, err := watch.New(wcfg, sch.watchStore)
+		if err != nil {
+			return err
+		}
+		for _, ow := range old {
+			if ow.SameConfig(w) {
+				w = ow
+				break
 			}
 		}
-	})
-	c.OnRequest(func(r *colly.Request) {
-		log.Println("Visiting", r.URL.String())
-	})
-	c.OnError(func(e *colly.Response, err error) {
-		log.Println("Error on scrap", err)
-	})
-	c.Visit(URL)
-
-	log.Println("check site done")
+		log.Println("Url to check is set to ", w.URL())
+		watchers = append(watchers, w)
+	}
 	return nil
 }
 
-func (sch *Scheduler) doSchedule() error {
-	sch.monitoredURL = conf.Current.UrlToCheck
+func (sch *Scheduler) checkWatch(ctx context.Context, w *watch.Watcher, now time.Time) {
+	if !w.IsDue(now) {
+		return
+	}
+	res, err := w.Check(ctx, now)
+	if err != nil {
+		log.Println("Error on check site", err)
+		return
+	}
+	if res.Triggered {
+		log.Println("Site has changed to: ", res.Text)
+		info := idl.WebChange{Name: w.Name(), URL: w.URL(), Text: res.Text, Diff: res.Diff}
+		if err := sch.sendWebChangedAlarm(ctx, &info); err != nil {
+			log.Println("[checkWatch] error ", err)
+		}
+	}
+}
 
-	log.Println("Infinite scheduler loop")
-	if sch.monitoredURL != "" {
-		log.Println("Url to check is set to ", sch.monitoredURL)
+// doSchedule sleeps until the next action in the queue is due. Times are built
+// with time.Date in the configured location, so that DST changes are respected.
+func (sch *Scheduler) doSchedule(ctx context.Context) error {
+	log.Println("Event driven scheduler loop")
+	now := sch.now()
+	if err := sch.catchUp(ctx, now); err != nil {
+		return err
 	}
-	last_day := 0
-	last_month := time.Month(1)
-	last_year := 0
-	sleeped_time := -1
+	if err := sch.rollover(now); err != nil {
+		return err
+	}
+	sch.planWatches()
 	for {
-		now := time.Now()
-		if now.Year() > last_year {
-			log.Println("year change")
-			last_year = now.Year()
-			last_month = time.Month(1)
-			last_day = 0
+		if err := sch.runDueActions(ctx, now); err != nil {
+			return err
+		}
+		sch.planPending(now)
+
+		wait := maxSleep
+		if act := sch.queue.next(); act != nil && act.at.Sub(now) < wait {
+			wait = act.at.Sub(now)
 		}
-		if now.Month() > last_month {
-			log.Println("month change")
-			last_month = now.Month()
-			last_day = 0
+		if wait < 0 {
+			wait = 0
 		}
-		if now.Day() > last_day {
-			log.Println("day change")
-			last_day = now.Day()
-			if err := sch.reschedule(); err != nil {
+		reload := false
+		before := sch.clock.Now()
+		select {
+		case <-sch.clock.After(wait):
+		case <-sch.chReschedule:
+			log.Println("reschedule requested")
+			reload = true
+		case <-sch.chWake:
+			// an acknowledged watch is checked again
+			sch.planWatches()
+		case <-sch.chReload:
+			sch.reloadFiles()
+			reload = true
+		case <-ctx.Done():
+			log.Println("Scheduler loop stopped")
+			return nil
+		}
+		after := sch.clock.Now()
+		now = after.In(conf.Current().Location())
+		if clockJumped(before, after) {
+			log.Println("Wall clock jump detected, plan again from ", now)
+			reload = true
+		}
+		if reload {
+			if err := sch.rollover(now); err != nil {
 				return err
 			}
 		}
-		if sch.hasItems() && now.Hour() >= 9 {
-			log.Println("time to send an alarm ", now)
-			if err := sch.sendItemsAlarm(); err != nil {
+	}
+}
+
+func (sch *Scheduler) runDueActions(ctx context.Context, now time.Time) error {
+	for act := sch.queue.popDue(now); act != nil; act = sch.queue.popDue(now) {
+		if ctx.Err() != nil {
+			return nil
+		}
+		switch act.kind {
+		case actionDayRollover:
+			log.Println("day change")
+			if err := sch.rollover(now); err != nil {
 				return err
 			}
-		}
-		if sleeped_time == -1 || sleeped_time > 3600*6 {
-			if err := sch.checkSite(); err != nil {
+		case actionItemsAlarm:
+			if sch.deferQuiet(act, now) {
+				continue
+			}
+			if err := sch.sendTypeAlarm(ctx, act.eventType); err != nil {
+				return err
+			}
+		case actionDigest:
+			if sch.deferQuiet(act, now) {
+				continue
+			}
+			if err := sch.sendDigest(ctx, act.digest, now); err != nil {
+				return err
+			}
+		case actionWatchCheck:
+			sch.checkWatch(ctx, act.watch, now)
+			sch.planWatch(act.watch)
+		case actionRetry:
+			if err := sch.processRetries(ctx, now); err != nil {
+				return err
+			}
+		case actionSnoozed:
+			if err := sch.processSnoozed(ctx, now); err != nil {
+				return err
+			}
+		case actionEscalation:
+			if err := sch.processEscalations(ctx, now); err != nil {
 				return err
 			}
-			sleeped_time = 0
 		}
-		time.Sleep(60 * time.Second)
-		sleeped_time += 60
 	}
+	return nil
 }
 
-func (sch *Scheduler) reschedule() error {
-	schList, err := sch.readDataJsonFile()
-	if err != nil {
+// deferQuiet moves the action to the end of the quiet hours when now is inside them
+func (sch *Scheduler) deferQuiet(act *action, now time.Time) bool {
+	until, quiet := conf.Current().QuietUntil(now)
+	if !quiet {
+		return false
+	}
+	log.Printf("quiet hours, %s deferred to %v", act.key, until)
+	act.at = until
+	sch.queue.schedule(act)
+	return true
+}
+
+// rollover loads the events of the day and plans their alarms and the next day change
+func (sch *Scheduler) rollover(now time.Time) error {
+	if err := sch.reschedule(now); err != nil {
 		return err
 	}
-	return sch.scheduleNext(schList)
+	sch.planAlarms(now)
+	sch.planDigests(now)
+	if err := sch.setLastProcessed(now); err != nil {
+		return err
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	sch.queue.schedule(&action{key: "rollover", kind: actionDayRollover, at: midnight})
+	return nil
 }
 
-func (sch *Scheduler) readDataJsonFile() (*idl.SchedList, error) {
-	fname := sch.datafileName
-	log.Println("load scheduler json data ", fname)
-	if fname == "" {
-		return nil, fmt.Errorf("data file is empty")
+func (sch *Scheduler) planAlarms(now time.Time) {
+	pending := map[idl.EventType]bool{
+		idl.Birthday:    len(sch.nextBirthday) > 0,
+		idl.Anniversary: len(sch.nextAnniversary) > 0,
+	}
+	// birthdays first, when both alarms are due at the same time
+	for _, eventType := range []idl.EventType{idl.Birthday, idl.Anniversary} {
+		key := "alarm:" + eventType.String()
+		if !pending[eventType] {
+			sch.queue.cancel(key)
+			continue
+		}
+		hh, mm := conf.Current().AlarmClock(eventType.String())
+		at := time.Date(now.Year(), now.Month(), now.Day(), hh, mm, 0, 0, now.Location())
+		log.Printf("%s alarm planned at %v", eventType, at)
+		sch.queue.schedule(&action{key: key, kind: actionItemsAlarm, at: at, eventType: eventType.String()})
 	}
-	f, err := os.Open(fname)
-	if err != nil {
-		return nil, err
+}
+
+func (sch *Scheduler) planWatches() {
+	for _, w := range sch.watchers {
+		sch.planWatch(w)
+	}
+}
+
+func (sch *Scheduler) planWatch(w *watch.Watcher) {
+	key := watchKey(w)
+	at, ok := w.NextCheck()
+	if !ok {
+		sch.queue.cancel(key)
+		return
+	}
+	sch.queue.schedule(&action{key: key, kind: actionWatchCheck, at: at, watch: w})
+}
+
+func watchKey(w *watch.Watcher) string {
+	return "watch:" + w.URL() + "#" + w.Name()
+}
+
+// planPending plans the retries, the snoozed alarms and the escalations,
+// they change after every action or from the bot and the web server
+func (sch *Scheduler) planPending(now time.Time) {
+	var next time.Time
+	for _, pd := range sch.retryQueue {
+		if next.IsZero() || pd.nextTry.Before(next) {
+			next = pd.nextTry
+		}
+	}
+	sch.planAt("retry", actionRetry, next, !next.IsZero())
+	next, ok := sch.journal.NextSnooze()
+	sch.planAt("snoozed", actionSnoozed, afterQuietHours(next), ok)
+	next, ok = sch.nextEscalation()
+	sch.planAt("escalation", actionEscalation, afterQuietHours(next), ok)
+}
+
+func (sch *Scheduler) planAt(key string, kind actionKind, at time.Time, ok bool) {
+	if !ok {
+		sch.queue.cancel(key)
+		return
+	}
+	sch.queue.schedule(&action{key: key, kind: kind, at: at})
+}
+
+// afterQuietHours moves t to the end of the quiet hours
+func afterQuietHours(t time.Time) time.Time {
+	if until, quiet := conf.Current().QuietUntil(t); quiet {
+		return until
+	}
+	return t
+}
+
+func (sch *Scheduler) now() time.Time {
+	return sch.clock.Now().In(conf.Current().Location())
+}
+
+// wake lets the scheduler loop plan again, after an alarm is snoozed or acknowledged
+func (sch *Scheduler) wake() {
+	select {
+	case sch.chWake <- struct{}{}:
+	default:
+	}
+}
+
+// Reschedule asks the scheduler loop to reload the data file
+func (sch *Scheduler) Reschedule() {
+	select {
+	case sch.chReschedule <- struct{}{}:
+	default:
+	}
+}
+
+func (sch *Scheduler) Channels() []string {
+	sch.mu.RLock()
+	defer sch.mu.RUnlock()
+	return sch.notifiers.Names()
+}
+
+func (sch *Scheduler) DeliveryHistory(channel string, n int) []journal.Record {
+	return sch.journal.LastRecords(channel, n)
+}
+
+func (sch *Scheduler) WatchStatus() []idl.WatchStatus {
+	sch.mu.RLock()
+	defer sch.mu.RUnlock()
+	res := make([]idl.WatchStatus, 0, len(sch.watchers))
+	for _, w := range sch.watchers {
+		st := w.State()
+		res = append(res, idl.WatchStatus{
+			Name:          w.Name(),
+			URL:           w.URL(),
+			Status:        st.Status,
+			CheckedAt:     st.CheckedAt,
+			TriggeredAt:   st.TriggeredAt,
+			CooldownUntil: st.CooldownUntil,
+		})
+	}
+	return res
+}
+
+// AckWatch confirms a triggered watch, a stopped one is checked again
+func (sch *Scheduler) AckWatch(name string) error {
+	sch.mu.RLock()
+	defer sch.mu.RUnlock()
+	for _, w := range sch.watchers {
+		if w.Name() != name {
+			continue
+		}
+		if err := w.Acknowledge(sch.now()); err != nil {
+			return err
+		}
+		sch.wake()
+		return nil
 	}
-	defer f.Close()
-	schList := idl.SchedList{}
+	return fmt.Errorf("watch %q not found", name)
+}
 
-	err = json.NewDecoder(f).Decode(&schList)
+func (sch *Scheduler) reschedule(now time.Time) error {
+	schList, err := sch.store.Load()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	log.Println("Loaded scheduler from file ", fname, schList)
-	return &schList, nil
+	return sch.scheduleNext(schList, now)
 }
 
-func (sch *Scheduler) scheduleNext(schList *idl.SchedList) error {
+func (sch *Scheduler) scheduleNext(schList *idl.SchedList, now time.Time) error {
 	sch.nextBirthday = make([]*idl.SchedNextItem, 0)
 	sch.nextAnniversary = make([]*idl.SchedNextItem, 0)
-	now := time.Now()
 	log.Println("Schedule next for ", now)
 
-	yy := now.Year()
-	for _, item := range schList.List {
-		tmp_arr := strings.Split(item.MonthDay, "-")
-		if len(tmp_arr) != 2 {
-			return fmt.Errorf("expect month-day format, but get %s", item.MonthDay)
+	reminders, err := schList.Reminders(now, conf.Current().LeadDays)
+	if err != nil {
+		return err
+	}
+	for _, nextItem := range reminders {
+		if sch.isDeliveredOnAllChannels(nextItem) {
+			log.Println("alarm already delivered for today", nextItem)
+			continue
 		}
-		mm, err := monthToMonth(tmp_arr[0])
-		if err != nil {
-			return err
+		log.Println("candidate for today alarm", nextItem)
+		if nextItem.EventType == idl.Birthday {
+			sch.nextBirthday = append(sch.nextBirthday, nextItem)
 		}
-		dd, err := strconv.Atoi(tmp_arr[1])
-		if err != nil {
-			return err
-		}
-		time_item := time.Date(yy, mm, dd, 23, 59, 0, 0, time.Local)
-		if time_item.Unix() > now.Unix() {
-			nextItem := idl.SchedNextItem{Name: item.Name, Note: item.Note, Time: time_item}
-			err = nextItem.SetEventType(item.Type)
-			//log.Println("check ", nextItem)
-			if err != nil {
-				return err
-			}
-			if (now.Day() == time_item.Day()) &&
-				(now.Month() == time_item.Month()) {
-				log.Println("candidate for today alarm", nextItem)
-				if nextItem.EventType == idl.Birthday {
-					sch.nextBirthday = append(sch.nextBirthday, &nextItem)
-				}
-				if nextItem.EventType == idl.Anniversary {
-					sch.nextAnniversary = append(sch.nextAnniversary, &nextItem)
-				}
-			}
+		if nextItem.EventType == idl.Anniversary {
+			sch.nextAnniversary = append(sch.nextAnniversary, nextItem)
 		}
 	}
 	found := false
@@ -225,145 +496,145 @@ func (sch *Scheduler) scheduleNext(schList *idl.SchedList) error {
 	return nil
 }
 
-func monthToMonth(s string) (time.Month, error) {
-	switch s {
-	case "Gen":
-		return time.Month(1), nil
-	case "Feb":
-		return time.Month(2), nil
-	case "Mar":
-		return time.Month(3), nil
-	case "Apr":
-		return time.Month(4), nil
-	case "Mag":
-		return time.Month(5), nil
-	case "Giu":
-		return time.Month(6), nil
-	case "Lug":
-		return time.Month(7), nil
-	case "Ago":
-		return time.Month(8), nil
-	case "Set":
-		return time.Month(9), nil
-	case "Ott":
-		return time.Month(10), nil
-	case "Nov":
-		return time.Month(11), nil
-	case "Dic":
-		return time.Month(12), nil
-
-	}
-	return 0, fmt.Errorf("month not recongnized %s", s)
-}
-
-func (sch *Scheduler) hasItems() bool {
-	if len(sch.nextAnniversary) > 0 {
-		return true
+// sendTypeAlarm sends the alarm of the event type, Compl or Anniv
+func (sch *Scheduler) sendTypeAlarm(ctx context.Context, eventType string) error {
+	log.Println("time to send the alarm ", eventType)
+	if eventType == idl.Anniversary.String() {
+		return sch.sendAnniversaryAlarm(ctx)
 	}
-	if len(sch.nextBirthday) > 0 {
-		return true
-	}
-	return false
+	return sch.sendBirthdayAlarm(ctx)
 }
 
-func (sch *Scheduler) sendItemsAlarm() error {
-	if len(sch.nextBirthday) > 0 {
-		if err := sch.sendBirthdayAlarm(); err != nil {
-			return err
+// isDeliveredOnAllChannels is true when the item is delivered, or waiting for a
+// retry, on every channel and target
+func (sch *Scheduler) isDeliveredOnAllChannels(item *idl.SchedNextItem) bool {
+	for _, ch := range sch.itemChannels(item) {
+		if !sch.journal.IsDelivered(item.Key(), item.Occurrence(), ch) && !sch.isRetrying(item, ch) {
+			return false
 		}
 	}
-	if len(sch.nextAnniversary) > 0 {
-		if err := sch.sendAnniversaryAlarm(); err != nil {
-			return err
+	return true
+}
+
+// itemChannels returns the journal channels of the routes of the item
+func (sch *Scheduler) itemChannels(item *idl.SchedNextItem) []string {
+	res := make([]string, 0)
+	for _, nt := range sch.notifiers.Notifiers() {
+		for _, route := range sch.notifiers.Routes(nt, []*idl.SchedNextItem{item}) {
+			res = append(res, deliveryChannel(nt.Name(), route.Target))
 		}
 	}
-	return nil
+	return res
 }
 
-func (sch *Scheduler) sendBirthdayAlarm() error {
-	templ := "templates/birthday-mail.html"
-	if err := sendEmail(templ, sch.simulation, sch.nextBirthday); err != nil {
-		return err
-	}
-	if err := sendTelegram(templ, sch.simulation, sch.nextBirthday, sch.debug); err != nil {
-		return err
+// pendingItemsForChannel returns the items not yet delivered on the journal
+// channel, that includes the target of the route
+func (sch *Scheduler) pendingItemsForChannel(schItems []*idl.SchedNextItem, channel string) []*idl.SchedNextItem {
+	res := make([]*idl.SchedNextItem, 0)
+	for _, item := range schItems {
+		if sch.journal.IsDelivered(item.Key(), item.Occurrence(), channel) {
+			log.Println("skip item already delivered on ", channel, item.Name)
+			continue
+		}
+		if sch.isRetrying(item, channel) {
+			log.Println("skip item waiting for a retry on ", channel, item.Name)
+			continue
+		}
+		res = append(res, item)
 	}
+	return res
+}
 
-	sch.nextBirthday = make([]*idl.SchedNextItem, 0)
-	return nil
+// isRetrying is true when a delivery of the item on the channel is in the retry queue
+func (sch *Scheduler) isRetrying(item *idl.SchedNextItem, channel string) bool {
+	for _, pd := range sch.retryQueue {
+		if pd.channel() != channel {
+			continue
+		}
+		for _, queued := range pd.items {
+			if queued.Key() == item.Key() && queued.Occurrence() == item.Occurrence() {
+				return true
+			}
+		}
+	}
+	return false
 }
 
-func (sch *Scheduler) sendWebChangedAlarm(URL string) error {
-	templ := "templates/webchanged-mail.html"
-	if err := sendEmailForWeb(templ, sch.simulation, URL); err != nil {
-		return err
+func (sch *Scheduler) markDelivered(schItems []*idl.SchedNextItem, channel string) error {
+	if sch.simulation {
+		return nil
 	}
-	if err := sendTelegramForWeb(templ, sch.simulation, URL, sch.debug); err != nil {
-		return err
+	for _, item := range schItems {
+		if err := sch.journal.MarkDelivered(item.Key(), item.Occurrence(), channel); err != nil {
+			return err
+		}
 	}
-	sch.monitoredURL = ""
-
 	return nil
 }
 
-func (sch *Scheduler) sendAnniversaryAlarm() error {
-	templ := "templates/anniversary-mail.html"
-	if err := sendEmail(templ, sch.simulation, sch.nextAnniversary); err != nil {
-		return err
-	}
-	if err := sendTelegram(templ, sch.simulation, sch.nextAnniversary, sch.debug); err != nil {
-		return err
+// sendItemsOnChannels sends the items on each channel, all the copies share
+// the same alarm ID
+func (sch *Scheduler) sendItemsOnChannels(ctx context.Context, templ string, schItems []*idl.SchedNextItem) error {
+	alarmID := journal.NewAlarmID()
+	for _, nt := range sch.notifiers.Notifiers() {
+		for _, route := range sch.notifiers.Routes(nt, schItems) {
+			route.Items = sch.pendingItemsForChannel(route.Items, deliveryChannel(nt.Name(), route.Target))
+			if len(route.Items) == 0 {
+				continue
+			}
+			if err := sch.deliver(ctx, route, templ, route.Items, alarmID); err != nil {
+				return err
+			}
+		}
 	}
-	sch.nextAnniversary = make([]*idl.SchedNextItem, 0)
 	return nil
 }
 
-func sendEmail(templFileName string, simulation bool, schItems []*idl.SchedNextItem) error {
-	mail := mail.MailSender{}
-	mail.FillConf(simulation)
-	if err := mail.BuildEmailMsg(templFileName, schItems); err != nil {
-		return err
+// alarmNotifier returns the notifier of the channel for the targets of the alarm items
+func (sch *Scheduler) alarmNotifier(al *journal.Alarm) notify.Notifier {
+	nt := sch.notifiers.Get(al.Channel)
+	if nt == nil {
+		return nil
 	}
-	if err := mail.SendEmailViaRelay(); err != nil {
-		return err
+	routes := sch.notifiers.Routes(nt, al.Items)
+	for _, route := range routes {
+		if route.Target == al.Target {
+			return route.Notifier
+		}
 	}
-	return nil
+	if len(routes) > 0 {
+		return routes[0].Notifier
+	}
+	return nt
 }
 
-func sendEmailForWeb(templFileName string, simulation bool, URL string) error {
-	mail := mail.MailSender{}
-	mail.FillConf(simulation)
-	if err := mail.BuildEmailMsgWithURL(templFileName, URL); err != nil {
-		return err
-	}
-	if err := mail.SendEmailViaRelay(); err != nil {
+func (sch *Scheduler) sendBirthdayAlarm(ctx context.Context) error {
+	templ := "templates/birthday-mail.html"
+	if err := sch.sendItemsOnChannels(ctx, templ, sch.nextBirthday); err != nil {
 		return err
 	}
+
+	sch.nextBirthday = make([]*idl.SchedNextItem, 0)
 	return nil
 }
 
-func sendTelegram(templFileName string, simulation bool, schItems []*idl.SchedNextItem, debug bool) error {
-	ts := telegram.TelegramSender{}
-	ts.FillConf(simulation, debug)
-
-	if err := ts.BuildMsg(templFileName, schItems); err != nil {
-		return err
-	}
-	if err := ts.Send(); err != nil {
-		return err
+func (sch *Scheduler) sendWebChangedAlarm(ctx context.Context, info *idl.WebChange) error {
+	templ := "templates/webchanged-mail.html"
+	for _, nt := range sch.notifiers.Notifiers() {
+		for _, route := range sch.notifiers.Routes(nt, nil) {
+			if err := sch.deliver(ctx, route, templ, info, ""); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func sendTelegramForWeb(templFileName string, simulation bool, URL string, debug bool) error {
-	ts := telegram.TelegramSender{}
-	ts.FillConf(simulation, debug)
-
-	if err := ts.BuildMsgWithURL(templFileName, URL); err != nil {
-		return err
-	}
-	if err := ts.Send(); err != nil {
+func (sch *Scheduler) sendAnniversaryAlarm(ctx context.Context) error {
+	templ := "templates/anniversary-mail.html"
+	if err := sch.sendItemsOnChannels(ctx, templ, sch.nextAnniversary); err != nil {
 		return err
 	}
+	sch.nextAnniversary = make([]*idl.SchedNextItem, 0)
 	return nil
 }
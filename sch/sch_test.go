@@ -0,0 +1,242 @@
+package sch
+
+import (
+	"birthsch/conf"
+	"birthsch/datafile"
+	"birthsch/idl"
+	"birthsch/journal"
+	"birthsch/notify"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClock hands every sleep of the scheduler loop to the test, that moves
+// the time forward and wakes the loop up
+type fakeClock struct {
+	now   time.Time
+	sleep chan time.Duration
+	fire  chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.sleep <- d
+	return c.fire
+}
+
+// recordNotifier keeps the deliveries with the time of the fake clock
+type recordNotifier struct {
+	clock *fakeClock
+	sent  []string
+}
+
+func (rn *recordNotifier) Name() string {
+	return "record"
+}
+
+func (rn *recordNotifier) Notify(ctx context.Context, templFileName string, data interface{}) error {
+	items, ok := data.([]*idl.SchedNextItem)
+	if !ok {
+		return fmt.Errorf("unexpected data %T", data)
+	}
+	for _, item := range items {
+		rn.sent = append(rn.sent, item.Name+"@"+rn.clock.now.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// newTestScheduler returns a scheduler on the events with the config, the
+// deliveries go to the returned notifier
+func newTestScheduler(t *testing.T, config string, events []idl.SchedItem, start time.Time) (*Scheduler, *fakeClock, *recordNotifier) {
+	t.Helper()
+	dir := t.TempDir()
+	dataFile := filepath.Join(dir, "data.json")
+	if err := datafile.Write(dataFile, &idl.SchedList{List: events}); err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(dir, "config.toml")
+	config += fmt.Sprintf("\nDataFileName = %q\nJournalFileName = %q\n", dataFile, filepath.Join(dir, "journal.json"))
+	if err := os.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := conf.Load(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := conf.Current()
+	conf.SetCurrent(cfg)
+	t.Cleanup(func() { conf.SetCurrent(old) })
+
+	jr, err := journal.Open(cfg.JournalFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := &fakeClock{now: start, sleep: make(chan time.Duration), fire: make(chan time.Time)}
+	rn := &recordNotifier{clock: clk}
+	sch := &Scheduler{store: datafile.NewStore(dataFile),
+		chReschedule: make(chan struct{}, 1),
+		chWake:       make(chan struct{}, 1),
+		chReload:     make(chan struct{}, 1),
+		configfile:   configFile,
+		clock:        clk,
+		queue:        newActionQueue(),
+		journal:      jr,
+		notifiers:    notify.NewRegistryOf(cfg, rn),
+	}
+	return sch, clk, rn
+}
+
+// runUntil runs the scheduler loop and moves the fake clock until end
+func runUntil(t *testing.T, sch *Scheduler, clk *fakeClock, end time.Time) []time.Time {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chDone := make(chan error, 1)
+	go func() {
+		chDone <- sch.doSchedule(ctx)
+	}()
+	wakes := []time.Time{}
+	for {
+		select {
+		case d := <-clk.sleep:
+			if d > maxSleep {
+				t.Fatalf("the loop sleeps %v, more than %v", d, maxSleep)
+			}
+			next := clk.now.Add(d)
+			if next.After(end) {
+				cancel()
+				if err := <-chDone; err != nil {
+					t.Fatal(err)
+				}
+				return wakes
+			}
+			clk.now = next
+			wakes = append(wakes, next)
+			clk.fire <- next
+		case err := <-chDone:
+			t.Fatalf("scheduler loop stopped: %v", err)
+		}
+	}
+}
+
+func TestDoSchedule(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		alarm  string
+		events []idl.SchedItem
+		start  time.Time
+		end    time.Time
+		want   []string
+	}{
+		{
+			name:  "day rollover",
+			alarm: "09:00",
+			events: []idl.SchedItem{
+				{Name: "Max", MonthDay: "Ott-18", Type: "Compl"},
+				{Name: "Ada", MonthDay: "Ott-19", Type: "Compl"},
+				{Name: "Sposi", MonthDay: "Ott-19", Type: "Anniv"},
+			},
+			start: time.Date(2026, time.October, 18, 7, 0, 0, 0, rome),
+			end:   time.Date(2026, time.October, 20, 12, 0, 0, 0, rome),
+			want: []string{
+				"Max@2026-10-18T09:00:00+02:00",
+				"Ada@2026-10-19T09:00:00+02:00",
+				"Sposi@2026-10-19T09:00:00+02:00",
+			},
+		},
+		{
+			name:  "alarm time already passed at start",
+			alarm: "09:00",
+			events: []idl.SchedItem{
+				{Name: "Max", MonthDay: "Ott-18", Type: "Compl"},
+			},
+			start: time.Date(2026, time.October, 18, 15, 0, 0, 0, rome),
+			end:   time.Date(2026, time.October, 19, 12, 0, 0, 0, rome),
+			want:  []string{"Max@2026-10-18T15:00:00+02:00"},
+		},
+		{
+			// 02:30 does not exist on Mar 29, the clocks jump from 02:00 to 03:00
+			name:  "DST starts",
+			alarm: "02:30",
+			events: []idl.SchedItem{
+				{Name: "Sab", MonthDay: "Mar-28", Type: "Compl"},
+				{Name: "Dom", MonthDay: "Mar-29", Type: "Compl"},
+				{Name: "Lun", MonthDay: "Mar-30", Type: "Compl"},
+			},
+			start: time.Date(2026, time.March, 28, 0, 0, 0, 0, rome),
+			end:   time.Date(2026, time.March, 30, 12, 0, 0, 0, rome),
+			want: []string{
+				"Sab@2026-03-28T02:30:00+01:00",
+				"Dom@2026-03-29T03:30:00+02:00",
+				"Lun@2026-03-30T02:30:00+02:00",
+			},
+		},
+		{
+			// 02:30 happens twice on Oct 25, the alarm is sent once at the
+			// one that time.Date picks
+			name:  "DST ends",
+			alarm: "02:30",
+			events: []idl.SchedItem{
+				{Name: "Sab", MonthDay: "Ott-24", Type: "Compl"},
+				{Name: "Dom", MonthDay: "Ott-25", Type: "Compl"},
+				{Name: "Lun", MonthDay: "Ott-26", Type: "Compl"},
+			},
+			start: time.Date(2026, time.October, 24, 0, 0, 0, 0, rome),
+			end:   time.Date(2026, time.October, 26, 12, 0, 0, 0, rome),
+			want: []string{
+				"Sab@2026-10-24T02:30:00+02:00",
+				"Dom@" + time.Date(2026, time.October, 25, 2, 30, 0, 0, rome).Format(time.RFC3339),
+				"Lun@2026-10-26T02:30:00+01:00",
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := fmt.Sprintf("Timezone = %q\nAlarmTime = %q\n", "Europe/Rome", tc.alarm)
+			sch, clk, rn := newTestScheduler(t, config, tc.events, tc.start)
+			wakes := runUntil(t, sch, clk, tc.end)
+
+			if len(rn.sent) != len(tc.want) {
+				t.Fatalf("sent %v, want %v", rn.sent, tc.want)
+			}
+			for i := range tc.want {
+				if rn.sent[i] != tc.want[i] {
+					t.Errorf("delivery %d is %s, want %s", i, rn.sent[i], tc.want[i])
+				}
+			}
+			// the loop wakes up at every midnight to load the events of the day
+			for day := tc.start; day.Before(tc.end); day = day.AddDate(0, 0, 1) {
+				midnight := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, rome)
+				if midnight.After(tc.end) {
+					break
+				}
+				if !containsTime(wakes, midnight) {
+					t.Errorf("no wake up at the day change %v", midnight)
+				}
+			}
+			if last := sch.journal.LastProcessed(); last != tc.end.Format(dayFormat) {
+				t.Errorf("last processed day is %s, want %s", last, tc.end.Format(dayFormat))
+			}
+		})
+	}
+}
+
+func containsTime(list []time.Time, t time.Time) bool {
+	for _, item := range list {
+		if item.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package sch
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// a delivery in flight when the service stops has this time to complete
+	deliveryGrace = 20 * time.Second
+	// the service exits anyway when the scheduler is not done after this time
+	shutdownTimeout = 25 * time.Second
+)
+
+// withGrace returns a context that is canceled deliveryGrace after ctx, so that
+// a started delivery can complete and its outcome can be written in the journal.
+func withGrace(ctx context.Context) (context.Context, context.CancelFunc) {
+	gctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		select {
+		case <-time.After(deliveryGrace):
+			cancel()
+		case <-gctx.Done():
+		}
+	})
+	return gctx, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,375 @@
+package telegram
+
+import (
+	"birthsch/conf"
+	"birthsch/datafile"
+	"birthsch/idl"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram refuses longer messages, a longer reply is split
+const maxMessageLen = 4096
+
+const botHelp = `Commands:
+/next [days] - events of the next days, default 30
+/today - events of today
+/list - all the events by next date
+/add Name Gen-03 Compl note - add an event, Compl or Anniv, the year is optional: Gen-03-1976
+/remove Name [Gen-03] - remove an event
+/watches - status of the web watches
+/watches ack Name - confirm a triggered watch, a stopped one is checked again`
+
+var monthDayRe = regexp.MustCompile(`^[A-Za-z]{3}-\d{1,2}(-\d{4})?$`)
+
+// BotBackend is the part of the scheduler used by the bot
+type BotBackend interface {
+	Reschedule()
+	WatchStatus() []idl.WatchStatus
+	AckWatch(name string) error
+	AckAlarm(id string) error
+	SnoozeAlarm(id string, tomorrow bool) (time.Time, error)
+}
+
+// Bot answers the commands of the authorized chats using getUpdates long polling
+type Bot struct {
+	cfg        conf.Telegram
+	debug      bool
+	store      *datafile.Store
+	backend    BotBackend
+	authorized map[int64]bool
+	// the chats that receive the alarms can use their buttons
+	alarmChats map[int64]bool
+	api        *tgbotapi.BotAPI
+}
+
+func NewBot(cfg *conf.Telegram, debug bool, store *datafile.Store, backend BotBackend) *Bot {
+	bot := &Bot{cfg: *cfg, debug: debug, store: store, backend: backend, authorized: make(map[int64]bool), alarmChats: make(map[int64]bool)}
+	for _, id := range cfg.BotChatIDs() {
+		bot.authorized[id] = true
+	}
+	if len(bot.authorized) == 0 {
+		log.Println("[Bot] no authorized chat, the commands are refused")
+	}
+	for _, id := range cfg.ChatIDs() {
+		bot.alarmChats[id] = true
+	}
+	return bot
+}
+
+// Run processes the updates until chStop is closed
+func (bot *Bot) Run(chStop chan struct{}) error {
+	api, err := getClient(bot.cfg.APIString, bot.debug)
+	if err != nil {
+		return err
+	}
+	bot.api = api
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+	updates := api.GetUpdatesChan(u)
+	log.Println("[Bot] listen for commands")
+	for {
+		select {
+		case <-chStop:
+			api.StopReceivingUpdates()
+			log.Println("[Bot] stopped")
+			return nil
+		case update := <-updates:
+			bot.handleUpdate(&update)
+		}
+	}
+}
+
+func (bot *Bot) handleUpdate(update *tgbotapi.Update) {
+	if update.CallbackQuery != nil {
+		bot.handleCallback(update.CallbackQuery)
+		return
+	}
+	msg := update.Message
+	if msg == nil || !msg.IsCommand() {
+		return
+	}
+	if !bot.authorized[msg.Chat.ID] {
+		log.Println("[Bot] command from unauthorized chat ", msg.Chat.ID)
+		return
+	}
+	log.Println("[Bot] command ", msg.Command(), msg.CommandArguments())
+	var reply string
+	var err error
+	args := msg.CommandArguments()
+	switch msg.Command() {
+	case "next":
+		reply, err = bot.cmdNext(args)
+	case "today":
+		reply, err = bot.cmdNext("1")
+	case "list":
+		reply, err = bot.cmdList()
+	case "add":
+		reply, err = bot.cmdAdd(args)
+	case "remove":
+		reply, err = bot.cmdRemove(args)
+	case "watches":
+		if name, ok := strings.CutPrefix(args, "ack "); ok {
+			reply, err = bot.cmdAckWatch(strings.TrimSpace(name))
+		} else {
+			reply = bot.cmdWatches()
+		}
+	default:
+		reply = botHelp
+	}
+	if err != nil {
+		reply = "Error: " + err.Error()
+	}
+	bot.reply(msg.Chat.ID, reply)
+}
+
+// handleCallback processes the buttons of the alarm message
+func (bot *Bot) handleCallback(cq *tgbotapi.CallbackQuery) {
+	if cq.Message == nil || !(bot.authorized[cq.Message.Chat.ID] || bot.alarmChats[cq.Message.Chat.ID]) {
+		log.Println("[Bot] callback from unauthorized chat")
+		return
+	}
+	action, alarmID, _ := strings.Cut(cq.Data, ":")
+	log.Println("[Bot] callback ", action, alarmID)
+	var answer string
+	var err error
+	switch action {
+	case CallbackAck:
+		err = bot.backend.AckAlarm(alarmID)
+		answer = "Done, well done!"
+	case CallbackSnoozeEvening, CallbackSnoozeTomorrow:
+		var until time.Time
+		until, err = bot.backend.SnoozeAlarm(alarmID, action == CallbackSnoozeTomorrow)
+		answer = "I will remind you on " + until.Format("Mon 02 Jan 15:04")
+	default:
+		err = fmt.Errorf("unknown action %q", action)
+	}
+	if err != nil {
+		answer = "Error: " + err.Error()
+	}
+	if _, err := bot.api.Request(tgbotapi.NewCallback(cq.ID, answer)); err != nil {
+		log.Println("[Bot] callback answer error ", err)
+	}
+	if err == nil {
+		// the edit removes the buttons from the alarm message
+		edit := tgbotapi.NewEditMessageText(cq.Message.Chat.ID, cq.Message.MessageID, cq.Message.Text+"\n\n"+answer)
+		if _, err := bot.api.Request(edit); err != nil {
+			log.Println("[Bot] edit alarm message error ", err)
+		}
+	}
+}
+
+func (bot *Bot) reply(chatID int64, text string) {
+	for _, part := range splitMessage(text, maxMessageLen) {
+		if _, err := bot.api.Send(tgbotapi.NewMessage(chatID, part)); err != nil {
+			log.Println("[Bot] reply error ", err)
+			return
+		}
+	}
+}
+
+// splitMessage splits the text at the line ends in parts of at most max bytes
+func splitMessage(text string, max int) []string {
+	res := make([]string, 0, 1)
+	for len(text) > max {
+		cut := strings.LastIndex(text[:max], "\n")
+		if cut <= 0 {
+			cut = max
+		}
+		res = append(res, text[:cut])
+		text = strings.TrimPrefix(text[cut:], "\n")
+	}
+	return append(res, text)
+}
+
+func (bot *Bot) cmdNext(args string) (string, error) {
+	days := 30
+	if args = strings.TrimSpace(args); args != "" {
+		var err error
+		if days, err = strconv.Atoi(args); err != nil || days < 1 || days > 366 {
+			return "", fmt.Errorf("days must be between 1 and 366")
+		}
+	}
+	list, err := bot.upcoming(days)
+	if err != nil {
+		return "", err
+	}
+	if len(list) == 0 {
+		if days == 1 {
+			return "Nothing for today", nil
+		}
+		return fmt.Sprintf("Nothing in the next %d days", days), nil
+	}
+	return formatNextItems(list), nil
+}
+
+// cmdList lists all the events sorted by the next date, like the list command
+func (bot *Bot) cmdList() (string, error) {
+	// every event occurs within one year and a day (Feb 29 excluded)
+	list, err := bot.upcoming(367)
+	if err != nil {
+		return "", err
+	}
+	if len(list) == 0 {
+		return "No events, add one with /add", nil
+	}
+	return formatNextItems(list), nil
+}
+
+func (bot *Bot) upcoming(days int) ([]*idl.SchedNextItem, error) {
+	schList, err := bot.store.Load()
+	if err != nil {
+		return nil, err
+	}
+	return schList.Upcoming(conf.Current().Now(), days)
+}
+
+func formatNextItems(list []*idl.SchedNextItem) string {
+	var sb strings.Builder
+	for _, item := range list {
+		sb.WriteString(formatNextItem(item))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func formatNextItem(item *idl.SchedNextItem) string {
+	res := fmt.Sprintf("%s %s (%s)", item.Time.Format("Mon 02 Jan"), item.Name, item.EventType)
+	if item.Years > 0 {
+		res += fmt.Sprintf(" %d years", item.Years)
+		if item.Milestone {
+			res += " *"
+		}
+	}
+	switch item.DaysLeft {
+	case 0:
+		res += " today"
+	case 1:
+		res += " tomorrow"
+	default:
+		res += fmt.Sprintf(" in %d days", item.DaysLeft)
+	}
+	if item.Note != "" {
+		res += " - " + item.Note
+	}
+	return res
+}
+
+// cmdAdd parses "Name with spaces Gen-03[-1976] Compl note with spaces"
+func (bot *Bot) cmdAdd(args string) (string, error) {
+	tokens := strings.Fields(args)
+	ix := -1
+	for i, tk := range tokens {
+		if monthDayRe.MatchString(tk) {
+			ix = i
+			break
+		}
+	}
+	if ix < 1 || ix+1 >= len(tokens) {
+		return "", fmt.Errorf("use /add Name Gen-03 Compl note")
+	}
+	item := idl.SchedItem{
+		Name:     strings.Join(tokens[:ix], " "),
+		MonthDay: tokens[ix],
+		Type:     tokens[ix+1],
+		Note:     strings.Join(tokens[ix+2:], " "),
+	}
+	if err := item.Validate(); err != nil {
+		return "", err
+	}
+	err := bot.store.Update(func(schList *idl.SchedList) error {
+		schList.List = append(schList.List, item)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	bot.backend.Reschedule()
+	return fmt.Sprintf("Added %s on %s (%s)", item.Name, item.MonthDay, item.Type), nil
+}
+
+// cmdRemove removes the event by name, the date is needed when the name is not unique
+func (bot *Bot) cmdRemove(args string) (string, error) {
+	tokens := strings.Fields(args)
+	if len(tokens) == 0 {
+		return "", fmt.Errorf("use /remove Name [Gen-03]")
+	}
+	monthDay := ""
+	if last := tokens[len(tokens)-1]; len(tokens) > 1 && monthDayRe.MatchString(last) {
+		monthDay = last
+		tokens = tokens[:len(tokens)-1]
+	}
+	name := strings.Join(tokens, " ")
+	var removed idl.SchedItem
+	err := bot.store.Update(func(schList *idl.SchedList) error {
+		found := []int{}
+		for i, item := range schList.List {
+			if !strings.EqualFold(item.Name, name) {
+				continue
+			}
+			if monthDay != "" && !sameDay(item.MonthDay, monthDay) {
+				continue
+			}
+			found = append(found, i)
+		}
+		switch len(found) {
+		case 0:
+			return fmt.Errorf("event %s not found", name)
+		case 1:
+			removed = schList.List[found[0]]
+			schList.List = append(schList.List[:found[0]], schList.List[found[0]+1:]...)
+			return nil
+		}
+		dates := []string{}
+		for _, i := range found {
+			dates = append(dates, schList.List[i].MonthDay)
+		}
+		return fmt.Errorf("%s has more events (%s), add the date", name, strings.Join(dates, ", "))
+	})
+	if err != nil {
+		return "", err
+	}
+	bot.backend.Reschedule()
+	return fmt.Sprintf("Removed %s on %s (%s)", removed.Name, removed.MonthDay, removed.Type), nil
+}
+
+func (bot *Bot) cmdAckWatch(name string) (string, error) {
+	if err := bot.backend.AckWatch(name); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Watch %s acknowledged", name), nil
+}
+
+func (bot *Bot) cmdWatches() string {
+	list := bot.backend.WatchStatus()
+	if len(list) == 0 {
+		return "No web watch is configured"
+	}
+	var sb strings.Builder
+	for _, ws := range list {
+		fmt.Fprintf(&sb, "%s: %s", ws.Name, ws.Status)
+		if !ws.CheckedAt.IsZero() {
+			fmt.Fprintf(&sb, ", checked %s", ws.CheckedAt.Format("02 Jan 15:04"))
+		}
+		if !ws.TriggeredAt.IsZero() {
+			fmt.Fprintf(&sb, ", triggered %s", ws.TriggeredAt.Format("02 Jan 15:04"))
+		}
+		if ws.Status == "cooldown" {
+			fmt.Fprintf(&sb, ", until %s", ws.CooldownUntil.Format("02 Jan 15:04"))
+		}
+		fmt.Fprintf(&sb, "\n%s\n", ws.URL)
+	}
+	return sb.String()
+}
+
+func sameDay(md1, md2 string) bool {
+	m1, d1, _, err1 := (&idl.SchedItem{MonthDay: md1}).ParseDate()
+	m2, d2, _, err2 := (&idl.SchedItem{MonthDay: md2}).ParseDate()
+	return err1 == nil && err2 == nil && m1 == m2 && d1 == d2
+}
@@ -0,0 +1,30 @@
+package telegram
+
+import (
+	"log"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	clientMu sync.Mutex
+	client   *tgbotapi.BotAPI
+)
+
+// getClient returns the bot API shared by the sender and the bot, it is created on first use
+func getClient(apiString string, debug bool) (*tgbotapi.BotAPI, error) {
+	clientMu.Lock()
+	defer clientMu.Unlock()
+	if client != nil && client.Token == apiString {
+		return client, nil
+	}
+	bot, err := tgbotapi.NewBotAPI(apiString)
+	if err != nil {
+		return nil, err
+	}
+	bot.Debug = debug
+	log.Printf("[Telegram] Authorized on account %s", bot.Self.UserName)
+	client = bot
+	return client, nil
+}
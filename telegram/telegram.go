@@ -2,49 +2,108 @@ package telegram
 
 import (
 	"birthsch/conf"
-	"birthsch/idl"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
+	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+const (
+	CallbackAck            = "ack"
+	CallbackSnoozeEvening  = "snzE"
+	CallbackSnoozeTomorrow = "snzT"
+)
+
 type TelegramSender struct {
 	cfg      conf.Telegram
 	simulate bool
 	content  string
 	debug    bool
+	alarmID  string
+	ackURL   string
+	chats    []conf.TelegramChat
 }
 
 func (ts *TelegramSender) FillConf(simulate, debug bool) {
 	ts.simulate = simulate
-	ts.cfg = *conf.Current.Telegram
+	ts.cfg = *conf.Current().Telegram
 	ts.debug = debug
+	ts.chats = ts.cfg.DefaultTargets()
+	if conf.Current().Http != nil && conf.Current().Http.Enabled {
+		ts.ackURL = conf.Current().Http.PublicURL
+	}
 }
 
-func (ts *TelegramSender) BuildMsg(templFileName string, listsrc []*idl.SchedNextItem) error {
-	var partPlainContent bytes.Buffer
-	tmplBody := template.Must(template.New("MailBody").ParseFiles(templFileName))
-	if err := tmplBody.ExecuteTemplate(&partPlainContent, "mailPlain", listsrc); err != nil {
+func (ts *TelegramSender) Name() string {
+	return "telegram"
+}
+
+// ForChat returns a sender with the same bot for a single chat
+func (ts *TelegramSender) ForChat(chat conf.TelegramChat) *TelegramSender {
+	return &TelegramSender{cfg: ts.cfg, simulate: ts.simulate, debug: ts.debug, ackURL: ts.ackURL, chats: []conf.TelegramChat{chat}}
+}
+
+// Chats are the chats the messages are sent to
+func (ts *TelegramSender) Chats() []conf.TelegramChat {
+	return ts.chats
+}
+
+func (ts *TelegramSender) Notify(ctx context.Context, templFileName string, data interface{}) error {
+	if err := ts.BuildMsg(templFileName, data); err != nil {
 		return err
 	}
-	ts.content = partPlainContent.String()
-	return nil
+	return ts.Send(ctx)
+}
+
+// NotifyWithAck sends the alarm with the buttons to acknowledge or snooze it
+func (ts *TelegramSender) NotifyWithAck(ctx context.Context, templFileName string, data interface{}, alarmID string) error {
+	if err := ts.BuildMsg(templFileName, data); err != nil {
+		return err
+	}
+	ts.alarmID = alarmID
+	defer func() { ts.alarmID = "" }()
+	return ts.Send(ctx)
+}
+
+// CanAck is true when the message has the bot buttons or the link to the ack page
+func (ts *TelegramSender) CanAck() bool {
+	return ts.cfg.EnableBot || ts.ackURL != ""
 }
 
-func (ts *TelegramSender) BuildMsgWithURL(templFileName string, URL string) error {
+func (ts *TelegramSender) BuildMsg(templFileName string, data interface{}) error {
 	var partPlainContent bytes.Buffer
-	tmplBody := template.Must(template.New("MailBody").ParseFiles(templFileName))
-	if err := tmplBody.ExecuteTemplate(&partPlainContent, "mailPlain", URL); err != nil {
+	tmplBody, err := template.New("MailBody").ParseFiles(templFileName)
+	if err != nil {
+		return err
+	}
+	if err := tmplBody.ExecuteTemplate(&partPlainContent, "mailPlain", data); err != nil {
 		return err
 	}
 	ts.content = partPlainContent.String()
 	return nil
 }
 
-func (ts *TelegramSender) Send() error {
+// Preview renders the message without sending it
+func (ts *TelegramSender) Preview(templFileName string, data interface{}) (string, error) {
+	if err := ts.BuildMsg(templFileName, data); err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(ts.chats))
+	for _, chat := range ts.chats {
+		names = append(names, chatLabel(chat))
+	}
+	return fmt.Sprintf("Chat: %s\n\n%s", strings.Join(names, ", "), ts.content), nil
+}
+
+// Send delivers the message to each chat, a failed chat does not stop the others.
+// The bot api has no context, when ctx is canceled Send returns without waiting
+// for the answer of the request.
+func (ts *TelegramSender) Send(ctx context.Context) error {
 	if !ts.cfg.SendTelegram {
 		log.Println("not send telegram")
 		return nil
@@ -57,20 +116,72 @@ func (ts *TelegramSender) Send() error {
 		log.Println("Telegram simulation, do nothing")
 		return nil
 	}
-	bot, err := tgbotapi.NewBotAPI(ts.cfg.APIString)
+	bot, err := getClient(ts.cfg.APIString, ts.debug)
 	if err != nil {
 		return err
 	}
-	bot.Debug = ts.debug
 
-	log.Printf("[Telegram] Authorized on account %s", bot.Self.UserName)
+	if len(ts.chats) == 0 {
+		return fmt.Errorf("no telegram chat is configured")
+	}
+
+	text := ts.content
+	var markup interface{}
+	if ts.alarmID != "" && ts.cfg.EnableBot {
+		markup = alarmKeyboard(ts.alarmID)
+	} else if ts.alarmID != "" && ts.ackURL != "" {
+		text += "\n\nConfirm: " + strings.TrimSuffix(ts.ackURL, "/") + "/ack/" + ts.alarmID
+	}
+	errs := make([]error, 0)
+	for _, chat := range ts.chats {
+		if err := sendMessage(ctx, bot, chat, text, markup); err != nil {
+			errs = append(errs, fmt.Errorf("chat %s: %w", chatLabel(chat), err))
+			continue
+		}
+		log.Println("[Telegram] message sent to ", chatLabel(chat))
+	}
+	return errors.Join(errs...)
+}
 
-	chat_id := ts.cfg.ChatID
-	msg := tgbotapi.NewMessage(chat_id, ts.content)
-	if _, err := bot.Send(msg); err != nil {
+// sendMessage uses the raw request because the api version has no message_thread_id
+func sendMessage(ctx context.Context, bot *tgbotapi.BotAPI, chat conf.TelegramChat, text string, markup interface{}) error {
+	params := make(tgbotapi.Params)
+	if err := params.AddFirstValid("chat_id", chat.ChatID, chat.Username); err != nil {
 		return err
 	}
-	log.Println("[Telegram] message sent")
+	params["text"] = text
+	params.AddNonZero("message_thread_id", chat.ThreadID)
+	if err := params.AddInterface("reply_markup", markup); err != nil {
+		return err
+	}
+	chErr := make(chan error, 1)
+	go func() {
+		_, err := bot.MakeRequest("sendMessage", params)
+		chErr <- err
+	}()
+	select {
+	case err := <-chErr:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	return nil
+func chatLabel(chat conf.TelegramChat) string {
+	if chat.Username != "" {
+		return fmt.Sprintf("%s (%s)", chat.Name, chat.Username)
+	}
+	return fmt.Sprintf("%s (%d)", chat.Name, chat.ChatID)
+}
+
+func alarmKeyboard(alarmID string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Done – wished", CallbackAck+":"+alarmID),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Remind me at 18:00", CallbackSnoozeEvening+":"+alarmID),
+			tgbotapi.NewInlineKeyboardButtonData("Remind me tomorrow", CallbackSnoozeTomorrow+":"+alarmID),
+		),
+	)
 }
@@ -0,0 +1,149 @@
+package vcard
+
+import (
+	"birthsch/idl"
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Card struct {
+	Name        string
+	Birthday    string
+	Anniversary string
+}
+
+// Parse reads all the cards of a .vcf file, vCard 3.0 and 4.0
+func Parse(r io.Reader) ([]*Card, error) {
+	res := make([]*Card, 0)
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var card *Card
+	var structName string
+	for _, line := range lines {
+		ix := strings.Index(line, ":")
+		if ix < 0 {
+			continue
+		}
+		name, value := strings.ToUpper(line[:ix]), line[ix+1:]
+		if ip := strings.Index(name, ";"); ip >= 0 {
+			name = name[:ip]
+		}
+		// grouped properties like item1.X-ANNIVERSARY
+		if ig := strings.LastIndex(name, "."); ig >= 0 {
+			name = name[ig+1:]
+		}
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCARD"):
+			card = &Card{}
+			structName = ""
+		case name == "END" && strings.EqualFold(value, "VCARD"):
+			if card != nil {
+				if card.Name == "" {
+					card.Name = structName
+				}
+				res = append(res, card)
+			}
+			card = nil
+		case card == nil:
+			continue
+		case name == "FN":
+			card.Name = strings.TrimSpace(unescapeValue(value))
+		case name == "N":
+			parts := strings.Split(value, ";")
+			if len(parts) >= 2 {
+				structName = strings.TrimSpace(unescapeValue(parts[1]) + " " + unescapeValue(parts[0]))
+			}
+		case name == "BDAY":
+			card.Birthday = value
+		case name == "ANNIVERSARY" || name == "X-ANNIVERSARY":
+			card.Anniversary = value
+		}
+	}
+	return res, nil
+}
+
+// ToSchedItems returns an item for the birthday and one for the anniversary, when present
+func (c *Card) ToSchedItems() ([]*idl.SchedItem, error) {
+	res := make([]*idl.SchedItem, 0)
+	if c.Name == "" {
+		return nil, fmt.Errorf("card without name")
+	}
+	for _, dt := range []struct {
+		value string
+		tt    idl.EventType
+	}{{c.Birthday, idl.Birthday}, {c.Anniversary, idl.Anniversary}} {
+		if dt.value == "" {
+			continue
+		}
+		mm, dd, yy, err := parseDate(dt.value)
+		if err != nil {
+			return nil, err
+		}
+		item := &idl.SchedItem{
+			Name:     c.Name,
+			MonthDay: fmt.Sprintf("%s-%02d", idl.MonthToString(time.Month(mm)), dd),
+			Type:     dt.tt.String(),
+			Year:     yy,
+		}
+		res = append(res, item)
+	}
+	return res, nil
+}
+
+// parseDate supports 1976-01-03, 19760103, --0103, --01-03 and a time part after T
+func parseDate(value string) (int, int, int, error) {
+	value = strings.TrimSpace(value)
+	if it := strings.Index(value, "T"); it >= 0 {
+		value = value[:it]
+	}
+	yy := 0
+	var md string
+	if strings.HasPrefix(value, "--") {
+		md = strings.ReplaceAll(value[2:], "-", "")
+	} else {
+		digits := strings.ReplaceAll(value, "-", "")
+		if len(digits) != 8 {
+			return 0, 0, 0, fmt.Errorf("date %q not recognized", value)
+		}
+		var err error
+		if yy, err = strconv.Atoi(digits[:4]); err != nil {
+			return 0, 0, 0, fmt.Errorf("date %q not recognized", value)
+		}
+		md = digits[4:]
+	}
+	if len(md) != 4 {
+		return 0, 0, 0, fmt.Errorf("date %q not recognized", value)
+	}
+	mm, err1 := strconv.Atoi(md[:2])
+	dd, err2 := strconv.Atoi(md[2:])
+	if err1 != nil || err2 != nil || mm < 1 || mm > 12 || dd < 1 || dd > 31 {
+		return 0, 0, 0, fmt.Errorf("date %q not recognized", value)
+	}
+	// some address books store 1604 as year when it is unknown
+	if yy == 1604 {
+		yy = 0
+	}
+	return mm, dd, yy, nil
+}
+
+func unescapeValue(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
@@ -0,0 +1,126 @@
+package watch
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContext  = 3
+	diffMaxCells = 4000000
+)
+
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns the line diff of a and b in the unified format
+func unifiedDiff(oldName, newName string, a, b []string) string {
+	ops := diffLines(a, b)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+
+	ia, ib := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			ia++
+			ib++
+			i++
+			continue
+		}
+		// hunk starts with the context before the first change
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		for k := start; k < i; k++ {
+			ia--
+			ib--
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			same := 0
+			for end+same < len(ops) && ops[end+same].kind == ' ' {
+				same++
+			}
+			if end+same == len(ops) || same > 2*diffContext {
+				end += min(same, diffContext)
+				break
+			}
+			end += same
+		}
+		countA, countB := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", ia+1, countA, ib+1, countB)
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		ia += countA
+		ib += countB
+		i = end
+	}
+	return sb.String()
+}
+
+func diffLines(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	if len(a)*len(b) > diffMaxCells {
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+		return ops
+	}
+	// longest common subsequence table, lcs[i][j] is for a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+	return ops
+}
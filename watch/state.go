@@ -0,0 +1,93 @@
+package watch
+
+import (
+	"birthsch/jsonfile"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StatusArmed        = "armed"
+	StatusTriggered    = "triggered"
+	StatusAcknowledged = "acknowledged"
+	StatusCooldown     = "cooldown"
+)
+
+type State struct {
+	Status         string
+	Hash           string
+	Snapshot       string
+	CheckedAt      time.Time
+	ChangedAt      time.Time
+	TriggerHash    string
+	TriggeredAt    time.Time
+	AcknowledgedAt time.Time
+	CooldownUntil  time.Time
+}
+
+// StateStore keeps the state of all watches in a json file, keyed by watch name
+type StateStore struct {
+	fname  string
+	mu     sync.Mutex
+	states map[string]*State
+}
+
+func OpenStateStore(fname string) (*StateStore, error) {
+	if fname == "" {
+		return nil, fmt.Errorf("watch state file name is empty")
+	}
+	ss := &StateStore{fname: fname, states: make(map[string]*State)}
+	found, err := jsonfile.Read(fname, &ss.states)
+	if err != nil {
+		return nil, fmt.Errorf("watch state %s is corrupted: %v", fname, err)
+	}
+	if found {
+		log.Println("Watch states loaded: ", len(ss.states))
+	}
+	return ss, nil
+}
+
+func (ss *StateStore) Get(name string) State {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if st, ok := ss.states[name]; ok {
+		return *st
+	}
+	return State{}
+}
+
+func (ss *StateStore) Set(name string, st State) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.states[name] = &st
+	return jsonfile.WriteAtomic(ss.fname, ss.states)
+}
+
+// normalizeText removes the white space differences that are not a content change
+func normalizeText(text string) string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func hashText(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
@@ -0,0 +1,244 @@
+package watch
+
+import (
+	"birthsch/conf"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+const (
+	ModeContains    = "contains"
+	ModeNotContains = "not-contains"
+	ModeRegex       = "regex"
+	ModeChanged     = "changed"
+)
+
+const (
+	AfterStop     = "stop"
+	AfterCooldown = "cooldown"
+	AfterRearm    = "rearm"
+)
+
+type Result struct {
+	Triggered bool
+	Text      string
+	Diff      string
+}
+
+type Watcher struct {
+	cfg       conf.Watch
+	re        *regexp.Regexp
+	store     *StateStore
+	nextCheck time.Time
+}
+
+func New(cfg *conf.Watch, store *StateStore) (*Watcher, error) {
+	w := &Watcher{cfg: *cfg, store: store}
+	if w.cfg.URL == "" {
+		return nil, fmt.Errorf("watch %q has no URL", w.cfg.Name)
+	}
+	if w.cfg.Name == "" {
+		w.cfg.Name = w.cfg.URL
+	}
+	if w.cfg.Selector == "" {
+		w.cfg.Selector = "body"
+	}
+	if w.cfg.IntervalMin <= 0 {
+		w.cfg.IntervalMin = 360
+	}
+	if w.cfg.CooldownHours <= 0 {
+		w.cfg.CooldownHours = 24
+	}
+	switch w.cfg.AfterTrigger {
+	case "":
+		w.cfg.AfterTrigger = AfterStop
+	case AfterStop, AfterCooldown, AfterRearm:
+	default:
+		return nil, fmt.Errorf("watch %q has unknown AfterTrigger %q", w.cfg.Name, w.cfg.AfterTrigger)
+	}
+	switch w.cfg.Mode {
+	case ModeContains, ModeNotContains:
+		if w.cfg.Pattern == "" {
+			return nil, fmt.Errorf("watch %q in mode %s needs a Pattern", w.cfg.Name, w.cfg.Mode)
+		}
+	case ModeRegex:
+		re, err := regexp.Compile(w.cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("watch %q has an invalid regex: %v", w.cfg.Name, err)
+		}
+		w.re = re
+	case ModeChanged:
+	default:
+		return nil, fmt.Errorf("watch %q has unknown mode %q", w.cfg.Name, w.cfg.Mode)
+	}
+	return w, nil
+}
+
+func (w *Watcher) Name() string {
+	return w.cfg.Name
+}
+
+func (w *Watcher) URL() string {
+	return w.cfg.URL
+}
+
+// SameConfig is true when both watchers check the same page in the same way
+func (w *Watcher) SameConfig(other *Watcher) bool {
+	return w.cfg == other.cfg
+}
+
+func (w *Watcher) State() State {
+	st := w.store.Get(w.cfg.Name)
+	if st.Status == "" {
+		st.Status = StatusArmed
+	}
+	return st
+}
+
+func (w *Watcher) IsDue(now time.Time) bool {
+	if now.Before(w.nextCheck) {
+		return false
+	}
+	st := w.State()
+	switch st.Status {
+	case StatusTriggered:
+		// a stopped watch waits for the acknowledge
+		return w.cfg.AfterTrigger != AfterStop
+	case StatusCooldown:
+		return !now.Before(st.CooldownUntil)
+	}
+	return true
+}
+
+// NextCheck returns when the watch is due again, false when a stopped
+// watch waits for the acknowledge
+func (w *Watcher) NextCheck() (time.Time, bool) {
+	st := w.State()
+	switch st.Status {
+	case StatusTriggered:
+		if w.cfg.AfterTrigger == AfterStop {
+			return time.Time{}, false
+		}
+	case StatusCooldown:
+		if w.nextCheck.Before(st.CooldownUntil) {
+			return st.CooldownUntil, true
+		}
+	}
+	return w.nextCheck, true
+}
+
+// Acknowledge confirms a triggered watch. It is armed again when the content changes.
+func (w *Watcher) Acknowledge(now time.Time) error {
+	st := w.State()
+	if st.Status == StatusArmed {
+		return nil
+	}
+	st.Status = StatusAcknowledged
+	st.AcknowledgedAt = now
+	log.Printf("[%s] watch acknowledged", w.cfg.Name)
+	return w.store.Set(w.cfg.Name, st)
+}
+
+// Check scrapes the page, the request is aborted when ctx is canceled. A page
+// without the selector is an error and the state is not changed.
+func (w *Watcher) Check(ctx context.Context, now time.Time) (*Result, error) {
+	w.nextCheck = now.Add(time.Duration(w.cfg.IntervalMin) * time.Minute)
+	text, err := w.scrape(ctx)
+	if err != nil {
+		return nil, err
+	}
+	text = normalizeText(text)
+	res := &Result{Text: text}
+	st := w.State()
+	hash := hashText(text)
+	switch st.Status {
+	case StatusCooldown:
+		log.Printf("[%s] cooldown is over, watch is armed", w.cfg.Name)
+		st.Status = StatusArmed
+	case StatusTriggered, StatusAcknowledged:
+		if hash != st.TriggerHash {
+			log.Printf("[%s] content changed after the trigger, watch is armed", w.cfg.Name)
+			st.Status = StatusArmed
+		}
+	}
+	if st.Status == StatusArmed {
+		w.evaluate(res, &st, hash)
+	}
+	if res.Triggered {
+		st.TriggerHash = hash
+		st.TriggeredAt = now
+		st.Status = StatusTriggered
+		if w.cfg.AfterTrigger == AfterCooldown {
+			st.Status = StatusCooldown
+			st.CooldownUntil = now.Add(time.Duration(w.cfg.CooldownHours) * time.Hour)
+		}
+	}
+	if st.Hash != hash {
+		st.ChangedAt = now
+	}
+	st.Hash = hash
+	st.Snapshot = text
+	st.CheckedAt = now
+	if err := w.store.Set(w.cfg.Name, st); err != nil {
+		return nil, err
+	}
+	log.Printf("[%s] site checked, triggered %v, status %s", w.cfg.Name, res.Triggered, st.Status)
+	return res, nil
+}
+
+func (w *Watcher) evaluate(res *Result, st *State, hash string) {
+	text := res.Text
+	switch w.cfg.Mode {
+	case ModeContains:
+		res.Triggered = strings.Contains(text, w.cfg.Pattern)
+	case ModeNotContains:
+		res.Triggered = !strings.Contains(text, w.cfg.Pattern)
+	case ModeRegex:
+		res.Triggered = w.re.MatchString(text)
+	case ModeChanged:
+		if st.Hash == "" {
+			log.Printf("[%s] first check, store the content as reference", w.cfg.Name)
+		} else if st.Hash != hash {
+			res.Triggered = true
+			res.Diff = unifiedDiff("previous", "current", splitLines(st.Snapshot), splitLines(text))
+		}
+	}
+}
+
+func (w *Watcher) scrape(ctx context.Context) (string, error) {
+	log.Println("Check URL for", w.cfg.URL)
+	texts := []string{}
+	c := colly.NewCollector()
+	c.WithTransport(&contextTransport{ctx: ctx, base: http.DefaultTransport})
+	c.OnHTML(w.cfg.Selector, func(e *colly.HTMLElement) {
+		texts = append(texts, strings.TrimSpace(e.Text))
+	})
+	c.OnRequest(func(r *colly.Request) {
+		log.Println("Visiting", r.URL.String())
+	})
+	if err := c.Visit(w.cfg.URL); err != nil {
+		return "", fmt.Errorf("error on scrap %s: %v", w.cfg.URL, err)
+	}
+	if len(texts) == 0 {
+		// a changed layout or an error page is not a content to evaluate
+		return "", fmt.Errorf("[%s] selector not found on the page: %s", w.cfg.Name, w.cfg.Selector)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// contextTransport binds the requests of the collector to the context
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
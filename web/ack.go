@@ -0,0 +1,40 @@
+package web
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+type ackData struct {
+	ID    string
+	Done  bool
+	Error string
+}
+
+// handleAck confirms an alarm, the alarm id in the link is the token:
+//
+//	GET  /ack/{id} shows the confirm button
+//	POST /ack/{id}
+func (ws *Server) handleAck(w http.ResponseWriter, r *http.Request) {
+	data := ackData{ID: strings.Trim(strings.TrimPrefix(r.URL.Path, "/ack/"), "/")}
+	if data.ID == "" || strings.Contains(data.ID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		ws.renderPage(w, "ack", &data)
+	case http.MethodPost:
+		if err := ws.backend.AckAlarm(data.ID); err != nil {
+			log.Println("[handleAck] error ", err)
+			data.Error = err.Error()
+			ws.renderPageStatus(w, http.StatusNotFound, "ack", &data)
+			return
+		}
+		data.Done = true
+		ws.renderPage(w, "ack", &data)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
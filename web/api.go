@@ -0,0 +1,226 @@
+package web
+
+import (
+	"birthsch/conf"
+	"birthsch/idl"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNotFound  = errors.New("event not found")
+	errDuplicate = errors.New("an event with the same name, type and day exists")
+)
+
+// Event is an item of the data file with its ID, see idl.SchedItem.EventID.
+// The ID changes when the name, the type or the day are changed.
+type Event struct {
+	ID string
+	idl.SchedItem
+}
+
+func newEvent(item idl.SchedItem) Event {
+	return Event{ID: item.EventID(), SchedItem: item}
+}
+
+// putItem replaces the event with the ID, or appends item when id is empty.
+// The item cannot take the ID of another event.
+func putItem(schList *idl.SchedList, id string, item *idl.SchedItem) error {
+	ix := len(schList.List)
+	if id != "" {
+		if ix = schList.Index(id); ix < 0 {
+			return errNotFound
+		}
+	}
+	if other := schList.Index(item.EventID()); other >= 0 && other != ix {
+		return errDuplicate
+	}
+	if ix == len(schList.List) {
+		schList.List = append(schList.List, *item)
+	} else {
+		schList.List[ix] = *item
+	}
+	return nil
+}
+
+func deleteItem(schList *idl.SchedList, id string) error {
+	ix := schList.Index(id)
+	if ix < 0 {
+		return errNotFound
+	}
+	schList.List = append(schList.List[:ix], schList.List[ix+1:]...)
+	return nil
+}
+
+// handleAPI routes:
+//
+//	GET    /api/events
+//	POST   /api/events
+//	GET    /api/events/{id}
+//	PUT    /api/events/{id}
+//	DELETE /api/events/{id}
+//	GET    /api/upcoming?days=N
+//	POST   /api/watches/ack?name=Name
+func (ws *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "upcoming" && r.Method == http.MethodGet:
+		ws.apiUpcoming(w, r)
+	case path == "events" && r.Method == http.MethodGet:
+		ws.apiList(w)
+	case path == "events" && r.Method == http.MethodPost:
+		ws.apiCreate(w, r)
+	case path == "watches/ack" && r.Method == http.MethodPost:
+		ws.apiAckWatch(w, r)
+	case len(parts) == 2 && parts[0] == "events":
+		id := parts[1]
+		switch r.Method {
+		case http.MethodGet:
+			ws.apiGet(w, id)
+		case http.MethodPut:
+			ws.apiUpdate(w, r, id)
+		case http.MethodDelete:
+			ws.apiDelete(w, id)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s %s not found", r.Method, r.URL.Path))
+	}
+}
+
+func (ws *Server) apiAckWatch(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if err := ws.backend.AckWatch(name); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"Acknowledged": name})
+}
+
+func (ws *Server) apiList(w http.ResponseWriter) {
+	schList, err := ws.store.Load()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res := make([]Event, 0, len(schList.List))
+	for _, item := range schList.List {
+		res = append(res, newEvent(item))
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (ws *Server) apiGet(w http.ResponseWriter, id string) {
+	schList, err := ws.store.Load()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ix := schList.Index(id)
+	if ix < 0 {
+		writeError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, newEvent(schList.List[ix]))
+}
+
+func (ws *Server) apiCreate(w http.ResponseWriter, r *http.Request) {
+	item, err := decodeItem(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	err = ws.store.Update(func(schList *idl.SchedList) error {
+		return putItem(schList, "", item)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	ws.backend.Reschedule()
+	writeJSON(w, http.StatusCreated, newEvent(*item))
+}
+
+func (ws *Server) apiUpdate(w http.ResponseWriter, r *http.Request, id string) {
+	item, err := decodeItem(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	err = ws.store.Update(func(schList *idl.SchedList) error {
+		return putItem(schList, id, item)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	ws.backend.Reschedule()
+	writeJSON(w, http.StatusOK, newEvent(*item))
+}
+
+func (ws *Server) apiDelete(w http.ResponseWriter, id string) {
+	err := ws.store.Update(func(schList *idl.SchedList) error {
+		return deleteItem(schList, id)
+	})
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	ws.backend.Reschedule()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ws *Server) apiUpcoming(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if s := r.URL.Query().Get("days"); s != "" {
+		var err error
+		if days, err = strconv.Atoi(s); err != nil || days < 1 || days > 366 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("days must be between 1 and 366"))
+			return
+		}
+	}
+	schList, err := ws.store.Load()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res, err := schList.Upcoming(conf.Current().Now(), days)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func decodeItem(r *http.Request) (*idl.SchedItem, error) {
+	// the ID of the body is ignored, it is the one in the path
+	ev := Event{}
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 64*1024))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&ev); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+	item := ev.SchedItem
+	if err := item.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+	return &item, nil
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if errors.Is(err, errDuplicate) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
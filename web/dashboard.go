@@ -0,0 +1,260 @@
+package web
+
+import (
+	"birthsch/conf"
+	"birthsch/idl"
+	"birthsch/journal"
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	dashboardTemplate = "templates/dashboard.html"
+	tokenCookie       = "birthsch_token"
+	historyPerChannel = 10
+)
+
+type channelHistory struct {
+	Channel string
+	Records []journal.Record
+}
+
+type dashboardData struct {
+	Days     int
+	Upcoming []*idl.SchedNextItem
+	Events   []Event
+	History  []channelHistory
+	Error    string
+}
+
+type formData struct {
+	Event      Event
+	Year       string
+	LeadDays   string
+	Recipients string
+	Chats      string
+	Tags       string
+	IsNew      bool
+	Error      string
+}
+
+func (ws *Server) renderPage(w http.ResponseWriter, name string, data interface{}) {
+	ws.renderPageStatus(w, http.StatusOK, name, data)
+}
+
+func (ws *Server) renderPageStatus(w http.ResponseWriter, code int, name string, data interface{}) {
+	tmpl, err := template.New("Dashboard").ParseFiles(dashboardTemplate)
+	if err != nil {
+		log.Println("[renderPage] template error ", err)
+		http.Error(w, "template error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	if err := tmpl.ExecuteTemplate(w, name, data); err != nil {
+		log.Println("[renderPage] error ", err)
+	}
+}
+
+func (ws *Server) requireLogin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(tokenCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(c.Value), []byte(ws.cfg.Token)) != 1 {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (ws *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ws.renderPage(w, "login", "")
+		return
+	}
+	token := r.PostFormValue("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(ws.cfg.Token)) != 1 {
+		log.Println("Login refused from ", r.RemoteAddr)
+		ws.renderPage(w, "login", "Wrong token")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     tokenCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   90 * 24 * 3600,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handlePages routes:
+//
+//	GET  /?days=N
+//	GET  /events/new
+//	GET  /events/{id}/edit
+//	POST /events/save
+//	POST /events/{id}/delete
+func (ws *Server) handlePages(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		ws.pageDashboard(w, r, "")
+	case path == "events/new" && r.Method == http.MethodGet:
+		ws.renderPage(w, "eventform", &formData{Event: Event{SchedItem: idl.SchedItem{Type: idl.Birthday.String()}}, IsNew: true})
+	case path == "events/save" && r.Method == http.MethodPost:
+		ws.pageSave(w, r)
+	case len(parts) == 3 && parts[0] == "events":
+		id := parts[1]
+		switch {
+		case parts[2] == "edit" && r.Method == http.MethodGet:
+			ws.pageEdit(w, r, id)
+		case parts[2] == "delete" && r.Method == http.MethodPost:
+			ws.pageDelete(w, r, id)
+		default:
+			http.NotFound(w, r)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ws *Server) pageDashboard(w http.ResponseWriter, r *http.Request, errMsg string) {
+	data := dashboardData{Days: 30, Error: errMsg}
+	if r.URL.Query().Get("days") == "90" {
+		data.Days = 90
+	}
+	schList, err := ws.store.Load()
+	if err != nil {
+		data.Error = err.Error()
+		ws.renderPage(w, "dashboard", &data)
+		return
+	}
+	if data.Upcoming, err = schList.Upcoming(conf.Current().Now(), data.Days); err != nil {
+		data.Error = err.Error()
+	}
+	for _, item := range schList.List {
+		data.Events = append(data.Events, newEvent(item))
+	}
+	for _, ch := range ws.backend.Channels() {
+		data.History = append(data.History, channelHistory{Channel: ch, Records: ws.backend.DeliveryHistory(ch, historyPerChannel)})
+	}
+	ws.renderPage(w, "dashboard", &data)
+}
+
+func (ws *Server) pageEdit(w http.ResponseWriter, r *http.Request, id string) {
+	schList, err := ws.store.Load()
+	if err != nil {
+		ws.pageDashboard(w, r, err.Error())
+		return
+	}
+	ix := schList.Index(id)
+	if ix < 0 {
+		http.NotFound(w, r)
+		return
+	}
+	item := schList.List[ix]
+	data := formData{Event: newEvent(item)}
+	if item.Year != 0 {
+		data.Year = strconv.Itoa(item.Year)
+	}
+	leads := []string{}
+	for _, lead := range item.LeadDays {
+		leads = append(leads, strconv.Itoa(lead))
+	}
+	data.LeadDays = strings.Join(leads, ",")
+	data.Recipients = strings.Join(item.Recipients, ", ")
+	data.Chats = strings.Join(item.Chats, ", ")
+	data.Tags = strings.Join(item.Tags, ", ")
+	ws.renderPage(w, "eventform", &data)
+}
+
+func (ws *Server) pageSave(w http.ResponseWriter, r *http.Request) {
+	data := formData{
+		Year:       strings.TrimSpace(r.PostFormValue("year")),
+		LeadDays:   strings.TrimSpace(r.PostFormValue("leaddays")),
+		Recipients: strings.TrimSpace(r.PostFormValue("recipients")),
+		Chats:      strings.TrimSpace(r.PostFormValue("chats")),
+		Tags:       strings.TrimSpace(r.PostFormValue("tags")),
+	}
+	// the ID of the event before the changes, empty for a new one
+	id := r.PostFormValue("id")
+	data.IsNew = id == ""
+	data.Event = Event{ID: id, SchedItem: idl.SchedItem{
+		Name:       strings.TrimSpace(r.PostFormValue("name")),
+		MonthDay:   strings.TrimSpace(r.PostFormValue("monthday")),
+		Type:       r.PostFormValue("type"),
+		Note:       strings.TrimSpace(r.PostFormValue("note")),
+		Escalation: strings.TrimSpace(r.PostFormValue("escalation")),
+		Group:      strings.TrimSpace(r.PostFormValue("group")),
+	}}
+	if err := parseFormItem(&data); err != nil {
+		data.Error = err.Error()
+		ws.renderPage(w, "eventform", &data)
+		return
+	}
+	err := ws.store.Update(func(schList *idl.SchedList) error {
+		return putItem(schList, id, &data.Event.SchedItem)
+	})
+	if err != nil {
+		data.Error = err.Error()
+		ws.renderPage(w, "eventform", &data)
+		return
+	}
+	ws.backend.Reschedule()
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (ws *Server) pageDelete(w http.ResponseWriter, r *http.Request, id string) {
+	err := ws.store.Update(func(schList *idl.SchedList) error {
+		return deleteItem(schList, id)
+	})
+	if err != nil {
+		ws.pageDashboard(w, r, err.Error())
+		return
+	}
+	ws.backend.Reschedule()
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func parseFormItem(data *formData) error {
+	item := &data.Event.SchedItem
+	if data.Year != "" {
+		yy, err := strconv.Atoi(data.Year)
+		if err != nil {
+			return fmt.Errorf("invalid year %q", data.Year)
+		}
+		item.Year = yy
+	}
+	if data.LeadDays != "" {
+		for _, s := range strings.Split(data.LeadDays, ",") {
+			lead, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("invalid lead days %q", data.LeadDays)
+			}
+			item.LeadDays = append(item.LeadDays, lead)
+		}
+	}
+	item.Recipients = splitList(data.Recipients)
+	item.Chats = splitList(data.Chats)
+	item.Tags = splitList(data.Tags)
+	return item.Validate()
+}
+
+// splitList returns the not empty values of a comma separated list
+func splitList(value string) []string {
+	var res []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"birthsch/conf"
+	"birthsch/datafile"
+	"birthsch/journal"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Backend is the part of the scheduler used by the web server
+type Backend interface {
+	Reschedule()
+	Channels() []string
+	DeliveryHistory(channel string, n int) []journal.Record
+	AckAlarm(id string) error
+	AckWatch(name string) error
+}
+
+type Server struct {
+	cfg     conf.Http
+	store   *datafile.Store
+	backend Backend
+	srv     *http.Server
+}
+
+func NewServer(cfg *conf.Http, store *datafile.Store, backend Backend) *Server {
+	return &Server{cfg: *cfg, store: store, backend: backend}
+}
+
+func (ws *Server) Start() error {
+	if ws.cfg.Token == "" {
+		return fmt.Errorf("http server needs a Token in the configuration")
+	}
+	ln, err := net.Listen("tcp", ws.cfg.Address)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/", ws.requireToken(ws.handleAPI))
+	mux.HandleFunc("/ack/", ws.handleAck)
+	mux.HandleFunc("/login", ws.handleLogin)
+	mux.HandleFunc("/", ws.requireLogin(ws.handlePages))
+	ws.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	log.Println("Http server listen on ", ln.Addr())
+	go func() {
+		if err := ws.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Println("Http server stopped with error: ", err)
+		}
+	}()
+	return nil
+}
+
+// Close lets the running requests finish before closing the listener
+func (ws *Server) Close() error {
+	if ws.srv == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ws.srv.Shutdown(ctx); err != nil {
+		return ws.srv.Close()
+	}
+	return nil
+}
+
+func (ws *Server) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Auth-Token")
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			token = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(ws.cfg.Token)) != 1 {
+			log.Println("Http request refused, wrong token from ", r.RemoteAddr)
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("[writeJSON] error ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, struct{ Error string }{Error: err.Error()})
+}